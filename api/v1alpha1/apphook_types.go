@@ -34,6 +34,54 @@ const (
 	// mysql param
 	MysqlTableLock    = "table"
 	MysqlInstanceLock = "instance"
+	// mariadb BACKUP STAGE
+	MysqlBackupStageLock = "backup-stage"
+	// percona LOCK TABLES FOR BACKUP
+	MysqlBackupLock = "backup-lock"
+	// select lock method by server flavor and version
+	MysqlAutoLock = "auto"
+	// stop sql applier thread on replica
+	MysqlReplicaLock = "replica"
+	// lock tables of the databases in spec only
+	MysqlDatabaseLock = "database"
+	// turn on super_read_only until unquiesce
+	MysqlReadOnlyLock = "read-only"
+	// turn to fully durable settings and flush logs during quiesce
+	DurableFlush = "durable-flush"
+	// seconds to wait for the lock
+	LockWaitTimeout = "lock-wait-timeout"
+	// seconds of running statement to be treated as lock blocker
+	LongQuerySeconds = "long-query-seconds"
+	// abort quiesce or kill blockers if lock blockers found
+	BlockerPolicy      = "blocker-policy"
+	BlockerPolicyAbort = "abort"
+	BlockerPolicyKill  = "kill"
+
+	// postgres param
+	// label of the backup, default is <hook name>-<spec name>
+	PgBackupLabel = "backup-label"
+	// checkpoint when backup starts, fast or spread
+	PgCheckpoint       = "checkpoint"
+	PgCheckpointFast   = "fast"
+	PgCheckpointSpread = "spread"
+	// wait for the wal segments of the backup archived on unquiesce
+	PgWaitForArchive = "wait-for-archive"
+	// exclusive or non-exclusive backup, default is exclusive before v15
+	PgBackupMode             = "backup-mode"
+	PgBackupModeExclusive    = "exclusive"
+	PgBackupModeNonExclusive = "non-exclusive"
+	// take backup on a streaming standby, the primary is not touched
+	PgStandby = "standby"
+	// pause wal replay on standby until unquiesce
+	PgReplayPause = "replay-pause"
+	// patroni rest api endpoint, patroni is paused during quiesce
+	PgPatroniEndpoint = "patroni-endpoint"
+	// export a snapshot for pg_dump --snapshot during quiesce
+	PgExportSnapshot = "export-snapshot"
+	// refuse or warn if settings make the snapshot unrecoverable
+	PgConfigCheck       = "config-check"
+	PgConfigCheckRefuse = "refuse"
+	PgConfigCheckWarn   = "warn"
 
 	// redis param
 	RedisBackupMethodByRDB = "rdb"
@@ -64,21 +112,109 @@ type AppHookSpec struct {
 }
 
 type QuiesceResult struct {
-	Mongo *MongoResult `json:"mongo,omitempty"`
-	Mysql *MysqlResult `json:"mysql,omitempty"`
-	Pg    *PgResult    `json:"pg,omitempty"`
-	Redis *RedisResult `json:"redis,omitempty"`
+	Mongo     *MongoResult     `json:"mongo,omitempty"`
+	Mysql     *MysqlResult     `json:"mysql,omitempty"`
+	Pg        *PgResult        `json:"pg,omitempty"`
+	PgBouncer *PgBouncerResult `json:"pgBouncer,omitempty"`
+	Redis     *RedisResult     `json:"redis,omitempty"`
 }
 
 type MongoResult struct {
 	MongoEndpoint string `json:"mongoEndpoint,omitempty"`
 	IsPrimary     bool   `json:"isPrimary,omitempty"`
+	// Shards are members locked in each shard and the config server replica
+	// set when the endpoint is mongos
+	Shards []MongoShardResult `json:"shards,omitempty"`
+}
+
+// MongoShardResult is the member locked in a replica set of sharded cluster
+type MongoShardResult struct {
+	// Shard is the shard id, or config for the config server replica set
+	Shard         string `json:"shard"`
+	ReplicaSet    string `json:"replicaSet,omitempty"`
+	MongoEndpoint string `json:"mongoEndpoint,omitempty"`
+	IsPrimary     bool   `json:"isPrimary,omitempty"`
 }
 
 type MysqlResult struct {
+	// LockMethod is the lock method used to quiesce
+	LockMethod string `json:"lockMethod,omitempty"`
+	// MemberEndpoint is the server quiesced, it's the selected member of group replication
+	MemberEndpoint string `json:"memberEndpoint,omitempty"`
+	IsPrimary      bool   `json:"isPrimary,omitempty"`
+	// binary log coordinates and gtid set captured when locked
+	BinlogFile     string `json:"binlogFile,omitempty"`
+	BinlogPosition int64  `json:"binlogPosition,omitempty"`
+	GtidExecuted   string `json:"gtidExecuted,omitempty"`
+	ServerUUID     string `json:"serverUUID,omitempty"`
+	// executed source binary log coordinates when replica applier is stopped
+	SourceLogFile     string `json:"sourceLogFile,omitempty"`
+	SourceLogPosition int64  `json:"sourceLogPosition,omitempty"`
+	// BackupStage is the last mariadb backup stage reached
+	BackupStage string `json:"backupStage,omitempty"`
+	// LockedTables are tables locked by database lock method
+	LockedTables []string `json:"lockedTables,omitempty"`
+	// Blockers are long running statements found before lock
+	Blockers []MysqlBlocker `json:"blockers,omitempty"`
+}
+
+// MysqlBlocker is a session from processlist which blocks the lock
+type MysqlBlocker struct {
+	ID      int64  `json:"id"`
+	User    string `json:"user,omitempty"`
+	Host    string `json:"host,omitempty"`
+	DB      string `json:"db,omitempty"`
+	Command string `json:"command,omitempty"`
+	Time    int64  `json:"time,omitempty"`
+	State   string `json:"state,omitempty"`
+	Info    string `json:"info,omitempty"`
+	Killed  bool   `json:"killed,omitempty"`
 }
 
 type PgResult struct {
+	// Database is the database connected to run the backup
+	Database string `json:"database,omitempty"`
+	// Databases are all databases of the cluster covered by the backup
+	Databases []string `json:"databases,omitempty"`
+	// ConfigMap in the namespace of the hook holding backup_label and
+	// tablespace_map, they must be put in data directory to restore the snapshot
+	BackupLabelConfigMap string `json:"backupLabelConfigMap,omitempty"`
+	// SystemIdentifier is the database system identifier of the cluster
+	SystemIdentifier string `json:"systemIdentifier,omitempty"`
+	// wal range the snapshot depends on, from backup start to backup stop
+	Timeline     int64  `json:"timeline,omitempty"`
+	StartLSN     string `json:"startLSN,omitempty"`
+	StartWalFile string `json:"startWalFile,omitempty"`
+	StopLSN      string `json:"stopLSN,omitempty"`
+	StopWalFile  string `json:"stopWalFile,omitempty"`
+	// RestorePoint is created right after the backup stops on unquiesce, it's
+	// the first consistent point of the snapshot, use it as recovery_target_name
+	RestorePoint    string `json:"restorePoint,omitempty"`
+	RestorePointLSN string `json:"restorePointLSN,omitempty"`
+	// ReplayLSN is the last wal location replayed when backup is taken on standby
+	IsStandby bool   `json:"isStandby,omitempty"`
+	ReplayLSN string `json:"replayLSN,omitempty"`
+	// SnapshotID is the exported snapshot kept until unquiesce, use it by
+	// pg_dump --snapshot to dump the same data as the volume snapshot
+	SnapshotID string `json:"snapshotID,omitempty"`
+	// Warnings are settings which may make the snapshot unrecoverable
+	Warnings []string `json:"warnings,omitempty"`
+	// CitusWorkers are workers of citus coordinator, the restore point is
+	// created on all of them
+	CitusWorkers []PgCitusWorker `json:"citusWorkers,omitempty"`
+}
+
+// PgCitusWorker is a primary worker from pg_dist_node
+type PgCitusWorker struct {
+	Host string `json:"host"`
+	Port int32  `json:"port"`
+	// LSN is the wal location of the worker after the restore point is created
+	LSN string `json:"lsn,omitempty"`
+}
+
+type PgBouncerResult struct {
+	// PausedDatabases are pooler databases paused until unquiesce
+	PausedDatabases []string `json:"pausedDatabases,omitempty"`
 }
 
 type RedisResult struct {
@@ -97,6 +233,9 @@ type AppHookStatus struct {
 	QuiescedTimestamp *metav1.Time     `json:"quiescedTimestamp,omitempty"`
 	Result            *QuiesceResult   `json:"result,omitempty"`
 	PreservedConfig   *PreservedConfig `json:"preservedConfig,omitempty"`
+	// SessionHeld is true if the quiesce is held by a database session, the
+	// quiesce is lost once the session is gone
+	SessionHeld bool `json:"sessionHeld,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -138,6 +277,7 @@ const (
 	HookNotReady            = "NotReady"
 	HookQUIESCEINPROGRESS   = "Quiesce In Progress"
 	HookQUIESCED            = "Quiesced"
+	HookQUIESCELOST         = "Quiesce Lost"
 	HookUNQUIESCEINPROGRESS = "Unquiesce In Progress"
 	HookUNQUIESCED          = "Unquiesced"
 )
@@ -149,6 +149,11 @@ func (in *AppHookStatus) DeepCopy() *AppHookStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MongoResult) DeepCopyInto(out *MongoResult) {
 	*out = *in
+	if in.Shards != nil {
+		in, out := &in.Shards, &out.Shards
+		*out = make([]MongoShardResult, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MongoResult.
@@ -161,9 +166,49 @@ func (in *MongoResult) DeepCopy() *MongoResult {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MongoShardResult) DeepCopyInto(out *MongoShardResult) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MongoShardResult.
+func (in *MongoShardResult) DeepCopy() *MongoShardResult {
+	if in == nil {
+		return nil
+	}
+	out := new(MongoShardResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MysqlBlocker) DeepCopyInto(out *MysqlBlocker) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MysqlBlocker.
+func (in *MysqlBlocker) DeepCopy() *MysqlBlocker {
+	if in == nil {
+		return nil
+	}
+	out := new(MysqlBlocker)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MysqlResult) DeepCopyInto(out *MysqlResult) {
 	*out = *in
+	if in.LockedTables != nil {
+		in, out := &in.LockedTables, &out.LockedTables
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Blockers != nil {
+		in, out := &in.Blockers, &out.Blockers
+		*out = make([]MysqlBlocker, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MysqlResult.
@@ -176,9 +221,59 @@ func (in *MysqlResult) DeepCopy() *MysqlResult {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PgBouncerResult) DeepCopyInto(out *PgBouncerResult) {
+	*out = *in
+	if in.PausedDatabases != nil {
+		in, out := &in.PausedDatabases, &out.PausedDatabases
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PgBouncerResult.
+func (in *PgBouncerResult) DeepCopy() *PgBouncerResult {
+	if in == nil {
+		return nil
+	}
+	out := new(PgBouncerResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PgCitusWorker) DeepCopyInto(out *PgCitusWorker) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PgCitusWorker.
+func (in *PgCitusWorker) DeepCopy() *PgCitusWorker {
+	if in == nil {
+		return nil
+	}
+	out := new(PgCitusWorker)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PgResult) DeepCopyInto(out *PgResult) {
 	*out = *in
+	if in.Databases != nil {
+		in, out := &in.Databases, &out.Databases
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Warnings != nil {
+		in, out := &in.Warnings, &out.Warnings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CitusWorkers != nil {
+		in, out := &in.CitusWorkers, &out.CitusWorkers
+		*out = make([]PgCitusWorker, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PgResult.
@@ -219,17 +314,22 @@ func (in *QuiesceResult) DeepCopyInto(out *QuiesceResult) {
 	if in.Mongo != nil {
 		in, out := &in.Mongo, &out.Mongo
 		*out = new(MongoResult)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Mysql != nil {
 		in, out := &in.Mysql, &out.Mysql
 		*out = new(MysqlResult)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Pg != nil {
 		in, out := &in.Pg, &out.Pg
 		*out = new(PgResult)
-		**out = **in
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PgBouncer != nil {
+		in, out := &in.PgBouncer, &out.PgBouncer
+		*out = new(PgBouncerResult)
+		(*in).DeepCopyInto(*out)
 	}
 	if in.Redis != nil {
 		in, out := &in.Redis, &out.Redis
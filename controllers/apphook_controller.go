@@ -30,6 +30,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -44,6 +45,8 @@ import (
 
 const (
 	HasStopWatchAnnotation = "apphooks.ys.jibudata.com/stop-watch"
+	BackupLabelSuffix      = "-backup-label"
+	BackupLabelTimeFormat  = "20060102150405"
 )
 
 // AppHookReconciler reconciles a AppHook object
@@ -57,6 +60,7 @@ type AppHookReconciler struct {
 //+kubebuilder:rbac:groups=ys.jibudata.com,resources=apphooks/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=ys.jibudata.com,resources=apphooks/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 
 func (r *AppHookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -120,6 +124,29 @@ func (r *AppHookReconciler) reconcile(instance *v1alpha1.AppHook) (ctrl.Result,
 		return reconcile.Result{}, nil
 	}
 
+	// check the session holding quiesce is still alive
+	sessionCheckTime := time.Duration(0)
+	if instance.Spec.OperationType == v1alpha1.QUIESCE && instance.Status.Phase == v1alpha1.HookQUIESCED {
+		mgr := r.AppMap[instance.Name]
+		if mgr != nil && mgr.DBSessionHeld() {
+			err = mgr.DBCheckSession()
+			if err != nil {
+				log.Log.Error(err, fmt.Sprintf("quiesce lost for %s", instance.Name))
+				instance.Status.Phase = v1alpha1.HookQUIESCELOST
+				instance.Status.ErrMsg = err.Error()
+				return reconcile.Result{}, r.updateStatus(instance)
+			}
+			sessionCheckTime = drivermanager.SessionCheckInterval
+		} else if instance.Status.SessionHeld {
+			// the session was closed together with the previous manager, e.g. controller restarted
+			err = fmt.Errorf("quiesce session of %s is not held by the controller", instance.Name)
+			log.Log.Error(err, fmt.Sprintf("quiesce lost for %s", instance.Name))
+			instance.Status.Phase = v1alpha1.HookQUIESCELOST
+			instance.Status.ErrMsg = err.Error()
+			return reconcile.Result{}, r.updateStatus(instance)
+		}
+	}
+
 	// quiesce timeout check
 	if instance.Spec.TimeoutSeconds != nil {
 		if *instance.Spec.TimeoutSeconds > 0 {
@@ -134,6 +161,9 @@ func (r *AppHookReconciler) reconcile(instance *v1alpha1.AppHook) (ctrl.Result,
 				} else { // not timeout, requeue again
 					nextReqSecond := time.Duration(*instance.Spec.TimeoutSeconds) - timePassedSecond
 					log.Log.Info(fmt.Sprintf("quiesce will be timeout after %d seconds for %s", nextReqSecond, instance.Name))
+					if sessionCheckTime > 0 && sessionCheckTime < nextReqSecond*time.Second {
+						return reconcile.Result{RequeueAfter: sessionCheckTime}, nil
+					}
 					return reconcile.Result{RequeueAfter: nextReqSecond * time.Second}, nil
 				}
 			}
@@ -202,6 +232,11 @@ func (r *AppHookReconciler) checkAndGetDBSConfig(instance *v1alpha1.AppHook, mgr
 		return nil, fmt.Errorf("failed to connect database for %s, err: %v", instance.Name, err)
 	}
 
+	// params changed by the quiesce in progress must not be saved
+	if mgr.DBSessionHeld() {
+		return instance.Status.PreservedConfig, nil
+	}
+
 	// add prepare action to save origin DB params before change from quiesce
 	preserved, err := mgr.DBPrepare()
 	if err != nil {
@@ -238,6 +273,7 @@ func (r *AppHookReconciler) ensureHookOperation(instance *v1alpha1.AppHook) (tim
 
 	// check operation type
 	var preserved *v1alpha1.PreservedConfig
+	var lostErr error
 	if instance.Spec.OperationType == "" { // new CR
 		instance.Status.Phase = v1alpha1.HookCreated
 
@@ -255,6 +291,10 @@ func (r *AppHookReconciler) ensureHookOperation(instance *v1alpha1.AppHook) (tim
 			instance.Status.PreservedConfig = preserved
 		}
 	} else if strings.EqualFold(instance.Spec.OperationType, v1alpha1.QUIESCE) {
+		// quiesce lost must be unquiesced explicitly
+		if instance.Status.Phase == v1alpha1.HookQUIESCELOST {
+			return requeueTime, nil
+		}
 		if instance.Status.Phase != v1alpha1.HookQUIESCED {
 			preserved, err = r.checkAndGetDBSConfig(instance, mgr)
 			if err != nil {
@@ -266,7 +306,12 @@ func (r *AppHookReconciler) ensureHookOperation(instance *v1alpha1.AppHook) (tim
 				result, err := mgr.DBQuiesce()
 				instance.Status.Result = result
 				instance.Status.PreservedConfig = preserved
-				if err != nil {
+				instance.Status.SessionHeld = mgr.DBSessionHeld()
+				if drivermanager.IsInProgress(err) {
+					log.Log.Info(fmt.Sprintf("quiesce for %s is still in progress", instance.Name))
+					instance.Status.Phase = v1alpha1.HookQUIESCEINPROGRESS
+					requeueTime = drivermanager.InProgressPollInterval
+				} else if err != nil {
 					log.Log.Error(err, fmt.Sprintf("failed to quiesce database for %s", instance.Name))
 					instance.Status.Phase = v1alpha1.HookQUIESCEINPROGRESS
 				} else {
@@ -279,6 +324,11 @@ func (r *AppHookReconciler) ensureHookOperation(instance *v1alpha1.AppHook) (tim
 				}
 			}
 		}
+		if instance.Status.Phase == v1alpha1.HookQUIESCED && mgr.DBSessionHeld() {
+			if requeueTime == 0 || requeueTime > drivermanager.SessionCheckInterval {
+				requeueTime = drivermanager.SessionCheckInterval
+			}
+		}
 	} else if strings.EqualFold(instance.Spec.OperationType, v1alpha1.UNQUIESCE) {
 		if instance.Status.Phase != v1alpha1.HookUNQUIESCED {
 			// connect to database to check status
@@ -289,17 +339,38 @@ func (r *AppHookReconciler) ensureHookOperation(instance *v1alpha1.AppHook) (tim
 			} else {
 				// unquiesce database
 				log.Log.Info(fmt.Sprintf("unquiesce for %s in progress", instance.Name))
+				mgr.DBRestoreResult(instance.Status.Result)
 				err = mgr.DBUnquiesce(instance.Status.PreservedConfig)
-				if err != nil {
-					log.Log.Error(err, fmt.Sprintf("failed to unquiesce database for %s", instance.Name))
+				if drivermanager.IsInProgress(err) {
+					log.Log.Info(fmt.Sprintf("unquiesce for %s is still in progress", instance.Name))
 					instance.Status.Phase = v1alpha1.HookUNQUIESCEINPROGRESS
+					requeueTime = drivermanager.InProgressPollInterval
+					err = nil
 				} else {
-					log.Log.Info(fmt.Sprintf("successfully unquiesce for %s", instance.Name))
-					instance.Status.Phase = v1alpha1.HookUNQUIESCED
-					// remove cached mgr
-					err = r.deleteDriverManager(instance)
+					if drivermanager.IsSessionLost(err) {
+						// the lock is released with the session, rest of unquiesce is done
+						log.Log.Error(err, fmt.Sprintf("quiesce lost before unquiesce for %s", instance.Name))
+						lostErr = err
+						err = nil
+					}
+					if err == nil {
+						if result := mgr.DBResult(); result != nil {
+							instance.Status.Result = result
+						}
+						err = r.saveBackupLabel(instance, mgr)
+					}
 					if err != nil {
-						return requeueTime, err
+						log.Log.Error(err, fmt.Sprintf("failed to unquiesce database for %s", instance.Name))
+						instance.Status.Phase = v1alpha1.HookUNQUIESCEINPROGRESS
+					} else {
+						log.Log.Info(fmt.Sprintf("successfully unquiesce for %s", instance.Name))
+						instance.Status.Phase = v1alpha1.HookUNQUIESCED
+						instance.Status.SessionHeld = false
+						// remove cached mgr
+						err = r.deleteDriverManager(instance)
+						if err != nil {
+							return requeueTime, err
+						}
 					}
 				}
 			}
@@ -311,10 +382,57 @@ func (r *AppHookReconciler) ensureHookOperation(instance *v1alpha1.AppHook) (tim
 	instance.Status.ErrMsg = ""
 	if err != nil {
 		instance.Status.ErrMsg = err.Error()
+	} else if lostErr != nil {
+		instance.Status.ErrMsg = lostErr.Error()
 	}
 	return requeueTime, err
 }
 
+// saveBackupLabel stores backup label files returned by unquiesce in a
+// configmap owned by the hook, the label files are kept by the cached driver
+// manager so it's retried until saved, each backup has its own configmap named
+// by the quiesced time so labels of earlier backups are kept
+func (r *AppHookReconciler) saveBackupLabel(instance *v1alpha1.AppHook, mgr *drivermanager.DriverManager) error {
+	files := mgr.DBBackupLabel()
+	if len(files) == 0 {
+		return nil
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getBackupLabelName(instance),
+			Namespace: instance.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, configMap, func() error {
+		configMap.Data = files
+		return controllerutil.SetControllerReference(instance, configMap, r.Scheme)
+	})
+	if err != nil {
+		log.Log.Error(err, fmt.Sprintf("failed to save backup label for %s", instance.Name))
+		return err
+	}
+
+	if instance.Status.Result == nil {
+		instance.Status.Result = &v1alpha1.QuiesceResult{}
+	}
+	if instance.Status.Result.Pg == nil {
+		instance.Status.Result.Pg = &v1alpha1.PgResult{}
+	}
+	instance.Status.Result.Pg.BackupLabelConfigMap = configMap.Name
+	log.Log.Info(fmt.Sprintf("backup label for %s is saved in configmap %s", instance.Name, configMap.Name))
+	return nil
+}
+
+// getBackupLabelName returns <hook name>-<quiesced time>-backup-label
+func getBackupLabelName(instance *v1alpha1.AppHook) string {
+	if instance.Status.QuiescedTimestamp == nil {
+		return instance.Name + BackupLabelSuffix
+	}
+	timestamp := instance.Status.QuiescedTimestamp.UTC().Format(BackupLabelTimeFormat)
+	return fmt.Sprintf("%s-%s%s", instance.Name, timestamp, BackupLabelSuffix)
+}
+
 func (r *AppHookReconciler) ensureRemoveHook(instance *v1alpha1.AppHook) error {
 	return r.deleteDriverManager(instance)
 }
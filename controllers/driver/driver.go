@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -15,17 +16,27 @@ import (
 	"github.com/jibudata/amberapp/pkg/appconfig"
 	"github.com/jibudata/amberapp/pkg/mongo"
 	"github.com/jibudata/amberapp/pkg/mysql"
+	"github.com/jibudata/amberapp/pkg/pgbouncer"
 	"github.com/jibudata/amberapp/pkg/postgres"
 	"github.com/jibudata/amberapp/pkg/redis"
+	"github.com/jibudata/amberapp/pkg/session"
 )
 
 type SupportedDB string
 
 const (
-	MySQL    SupportedDB = "MySQL"
-	Postgres SupportedDB = "Postgres"
-	MongoDB  SupportedDB = "MongoDB"
-	Redis    SupportedDB = "Redis"
+	MySQL     SupportedDB = "MySQL"
+	Postgres  SupportedDB = "Postgres"
+	MongoDB   SupportedDB = "MongoDB"
+	Redis     SupportedDB = "Redis"
+	PgBouncer SupportedDB = "PgBouncer"
+)
+
+const (
+	// interval to check the session holding the quiesce
+	SessionCheckInterval = 30 * time.Second
+	// interval to poll quiesce or unquiesce running in background
+	InProgressPollInterval = 5 * time.Second
 )
 
 type Database interface {
@@ -36,6 +47,32 @@ type Database interface {
 	Unquiesce(*v1alpha1.PreservedConfig) error
 }
 
+// SessionHolder is implemented by database which keeps a dedicated session
+// open between quiesce and unquiesce, quiesce is lost together with the session
+type SessionHolder interface {
+	IsSessionHeld() bool
+	CheckSession() error
+}
+
+// BackupLabelHolder is implemented by database which returns backup label
+// files when quiesce is stopped, the files are required to restore the snapshot
+type BackupLabelHolder interface {
+	// GetBackupLabel returns file name to content of the label files
+	GetBackupLabel() map[string]string
+}
+
+// ResultHolder is implemented by database which updates quiesce result when
+// quiesce is stopped
+type ResultHolder interface {
+	GetResult() *v1alpha1.QuiesceResult
+}
+
+// ResultRestorer is implemented by database which unquiesces by the quiesce
+// result, the result saved in status is restored after manager restarted
+type ResultRestorer interface {
+	RestoreResult(*v1alpha1.QuiesceResult)
+}
+
 type DriverManager struct {
 	client.Client
 	namespace string
@@ -62,6 +99,8 @@ func NewManager(k8sclient client.Client, instance *v1alpha1.AppHook, secret *cor
 		CacheManager.db = new(mongo.MG)
 	} else if strings.EqualFold(instance.Spec.AppProvider, string(Redis)) { // redis
 		CacheManager.db = new(redis.Redis)
+	} else if strings.EqualFold(instance.Spec.AppProvider, string(PgBouncer)) { // pgbouncer
+		CacheManager.db = new(pgbouncer.PgBouncer)
 	} else {
 		CacheManager.NotReady()
 		err = fmt.Errorf("provider %s is not supported", instance.Spec.AppProvider)
@@ -80,6 +119,7 @@ func NewManager(k8sclient client.Client, instance *v1alpha1.AppHook, secret *cor
 
 	CacheManager.appConfig = appconfig.Config{
 		Name:               instance.Name,
+		JobName:            instance.Spec.Name,
 		Host:               instance.Spec.EndPoint,
 		Databases:          instance.Spec.Databases,
 		Username:           string(secret.Data["username"]),
@@ -107,38 +147,51 @@ func (d *DriverManager) Update(instance *v1alpha1.AppHook, secret *corev1.Secret
 		return fmt.Errorf("apphook %s provider %s cannot be changed", d.appConfig.Name, d.appConfig.Provider)
 	}
 
+	// new config is applied only when the driver is re-initialized
+	newConfig := d.appConfig
 	isChanged := false
-	if d.appConfig.Host != instance.Spec.EndPoint {
-		d.appConfig.Host = instance.Spec.EndPoint
+	if newConfig.JobName != instance.Spec.Name {
+		newConfig.JobName = instance.Spec.Name
 		isChanged = true
 	}
-	if !equalStr(d.appConfig.Databases, instance.Spec.Databases) {
-		d.appConfig.Databases = instance.Spec.Databases
+	if newConfig.Host != instance.Spec.EndPoint {
+		newConfig.Host = instance.Spec.EndPoint
 		isChanged = true
 	}
-	if d.appConfig.Username != string(secret.Data["username"]) {
-		d.appConfig.Username = string(secret.Data["username"])
+	if !equalStr(newConfig.Databases, instance.Spec.Databases) {
+		newConfig.Databases = instance.Spec.Databases
 		isChanged = true
 	}
-	if d.appConfig.Password != string(secret.Data["password"]) {
-		d.appConfig.Password = string(secret.Data["password"])
+	if newConfig.Username != string(secret.Data["username"]) {
+		newConfig.Username = string(secret.Data["username"])
 		isChanged = true
 	}
-	if !reflect.DeepEqual(d.appConfig.Params, instance.Spec.Params) {
-		log.Log.Info("parameters changes", "new: ", instance.Spec.Params, "old: ", d.appConfig.Params)
-		d.appConfig.Params = instance.Spec.Params
+	if newConfig.Password != string(secret.Data["password"]) {
+		newConfig.Password = string(secret.Data["password"])
+		isChanged = true
+	}
+	if !reflect.DeepEqual(newConfig.Params, instance.Spec.Params) {
+		log.Log.Info("parameters changes", "new: ", instance.Spec.Params, "old: ", newConfig.Params)
+		newConfig.Params = instance.Spec.Params
 		isChanged = true
 	}
 
+	if isChanged && d.DBSessionHeld() {
+		// re-init would drop the session holding the quiesce, apply it after unquiesce
+		log.Log.Info(fmt.Sprintf("warning: %s configuration was changed when quiesce session is held, skip updating", d.appConfig.Name))
+		return nil
+	}
+
 	if isChanged {
 		log.Log.Info(fmt.Sprintf("detected %s configuration was changed, updating", d.appConfig.Name))
 		if instance.Status.Phase == v1alpha1.HookQUIESCED {
 			log.Log.Info(fmt.Sprintf("warning: %s hook status is quiesced when updating configuration", d.appConfig.Name))
 		}
-		err := d.db.Init(d.appConfig)
+		err := d.db.Init(newConfig)
 		if err != nil {
 			return err
 		}
+		d.appConfig = newConfig
 		err = d.db.Connect()
 		if err != nil {
 			return err
@@ -172,6 +225,58 @@ func (d *DriverManager) DBUnquiesce(prev *v1alpha1.PreservedConfig) error {
 	return d.db.Unquiesce(prev)
 }
 
+func (d *DriverManager) DBSessionHeld() bool {
+	holder, ok := d.db.(SessionHolder)
+	if !ok {
+		return false
+	}
+	return holder.IsSessionHeld()
+}
+
+func (d *DriverManager) DBCheckSession() error {
+	holder, ok := d.db.(SessionHolder)
+	if !ok {
+		return nil
+	}
+	return holder.CheckSession()
+}
+
+func (d *DriverManager) DBBackupLabel() map[string]string {
+	holder, ok := d.db.(BackupLabelHolder)
+	if !ok {
+		return nil
+	}
+	return holder.GetBackupLabel()
+}
+
+func (d *DriverManager) DBRestoreResult(result *v1alpha1.QuiesceResult) {
+	restorer, ok := d.db.(ResultRestorer)
+	if !ok {
+		return
+	}
+	restorer.RestoreResult(result)
+}
+
+func (d *DriverManager) DBResult() *v1alpha1.QuiesceResult {
+	holder, ok := d.db.(ResultHolder)
+	if !ok {
+		return nil
+	}
+	return holder.GetResult()
+}
+
+// IsSessionLost returns true if the session holding the quiesce was lost,
+// locks held by the session are released by server
+func IsSessionLost(err error) bool {
+	return errors.Is(err, session.ErrLost)
+}
+
+// IsInProgress returns true if quiesce or unquiesce is still running in
+// background, it's polled again later
+func IsInProgress(err error) bool {
+	return errors.Is(err, session.ErrInProgress)
+}
+
 func equalStr(str1, str2 []string) bool {
 	if len(str1) != len(str2) {
 		return false
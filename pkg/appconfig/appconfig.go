@@ -10,6 +10,7 @@ const (
 
 type Config struct {
 	Name               string
+	JobName            string
 	Host               string
 	Databases          []string
 	Username           string
@@ -19,6 +19,7 @@ package create
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -78,8 +79,7 @@ func (c *CreateOptions) BindFlags(flags *pflag.FlagSet, command *cobra.Command)
 	_ = command.MarkFlagRequired("app-provider")
 	flags.StringVarP(&c.Endpoint, "endpoint", "e", "", "database endpoint, e.g., 'service.namespace', or 'ip:port'")
 	_ = command.MarkFlagRequired("endpoint")
-	flags.StringArrayVar(&c.Databases, "databases", nil, "databases created inside the DB")
-	_ = command.MarkFlagRequired("databases")
+	flags.StringArrayVar(&c.Databases, "databases", nil, "databases created inside the DB, required by MySQL")
 	flags.StringVarP(&c.UserName, "username", "u", "", "username of the DB")
 	_ = command.MarkFlagRequired("username")
 	flags.StringVarP(&c.Password, "password", "p", "", "password for the DB user")
@@ -87,6 +87,11 @@ func (c *CreateOptions) BindFlags(flags *pflag.FlagSet, command *cobra.Command)
 }
 
 func (c *CreateOptions) Validate(command *cobra.Command, kubeclient *client.Client) error {
+	// other providers quiesce the whole server if no database specified
+	if strings.EqualFold(c.Provider, "MySQL") && len(c.Databases) == 0 {
+		return fmt.Errorf("databases is required by provider %s", c.Provider)
+	}
+
 	// Check WATCH_NAMESPACE, and if namespace exits, apphook operator is running
 	namespace, err := util.GetOperatorNamespace()
 	if err != nil {
@@ -18,6 +18,11 @@ import (
 
 type MG struct {
 	config appconfig.Config
+	// balancer mode before quiesce if connected to mongos
+	balancerMode string
+	// shard members locked by quiesce through mongos, unlocked by unquiesce
+	locked      []v1alpha1.MongoShardResult
+	lockedKnown bool
 }
 
 var log = ctrllog.Log.WithName("mongo")
@@ -59,6 +64,11 @@ func (mg *MG) Connect() error {
 		return err
 	}
 
+	if isMongos(result) {
+		log.Info("connected to mongos, one member of each shard and config server will be quiesced")
+		return nil
+	}
+
 	secondary := result["secondary"]
 
 	if secondary == false {
@@ -71,7 +81,36 @@ func (mg *MG) Connect() error {
 }
 
 func (mg *MG) Prepare() (*v1alpha1.PreservedConfig, error) {
-	return nil, nil
+	var result bson.M
+
+	mg.balancerMode = ""
+	client, err := getMongodbClient(mg.config)
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database("admin")
+
+	err = mg.runHello(db, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	if !isMongos(result) {
+		return nil, nil
+	}
+
+	// balancer is stopped during quiesce, keep it off on unquiesce if it was
+	status, err := getBalancerStatus(db)
+	if err != nil {
+		log.Error(err, "failed to get balancer status", "instance", mg.config.Name)
+		return nil, err
+	}
+	mg.balancerMode = status.Mode
+
+	return &v1alpha1.PreservedConfig{
+		Params: map[string]string{
+			BalancerMode: status.Mode,
+		},
+	}, nil
 }
 
 func (mg *MG) Quiesce() (*v1alpha1.QuiesceResult, error) {
@@ -95,6 +134,10 @@ func (mg *MG) Quiesce() (*v1alpha1.QuiesceResult, error) {
 		return nil, err
 	}
 
+	if isMongos(result) {
+		return mg.quiesceSharded(db)
+	}
+
 	secondary := result["secondary"]
 	primary := false
 	if secondary == false {
@@ -146,11 +189,33 @@ func (mg *MG) Unquiesce(prev *v1alpha1.PreservedConfig) error {
 		opts = options.RunCmd().SetReadPreference(readpref.Secondary())
 	}
 
+	var result bson.M
+	err = mg.runHello(db, opts, &result)
+	if err != nil {
+		log.Error(err, "failed to run hello")
+		return err
+	}
+	if isMongos(result) {
+		return mg.unquiesceSharded(db, prev)
+	}
+
+	err = unlockDB(db, opts)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to unquiesce %s", mg.config.Name))
+		return err
+	}
+
+	return nil
+}
+
+// unlockDB unlocks until lock count of fsync lock drops to zero
+func unlockDB(db *mongo.Database, opts *options.RunCmdOptions) error {
 	isLocked := true
 	for isLocked {
+		var err error
 		isLocked, err = isDBLocked(db, opts)
 		if err != nil {
-			log.Error(err, "failed to check lock status of database to unquiesce", "instance name", mg.config.Name)
+			log.Error(err, "failed to check lock status of database to unquiesce")
 			return err
 		}
 		if !isLocked {
@@ -163,7 +228,6 @@ func (mg *MG) Unquiesce(prev *v1alpha1.PreservedConfig) error {
 			if strings.Contains(result.Err().Error(), "not locked") {
 				return nil
 			}
-			log.Error(result.Err(), fmt.Sprintf("failed to unquiesce %s", mg.config.Name))
 			return result.Err()
 		}
 	}
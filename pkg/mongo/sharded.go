@@ -0,0 +1,360 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/appconfig"
+)
+
+const (
+	// hello msg of mongos
+	MongosMsg = "isdbgrid"
+	// shard name of config server replica set in result
+	ConfigServerShard = "config"
+
+	// balancer mode before quiesce, balancer is not restarted if it was off
+	BalancerMode    = "balancer-mode"
+	BalancerModeOff = "off"
+
+	// balancer stop is bounded separately, spec timeout is the quiesce hold time
+	DefaultBalancerStopTimeout = 5 * time.Minute
+	BalancerPollingInterval    = 2 * time.Second
+)
+
+// replicaSet is a shard or the config server replica set of a sharded cluster
+type replicaSet struct {
+	shard   string
+	setName string
+	hosts   []string
+}
+
+type shardList struct {
+	Shards []struct {
+		ID   string `bson:"_id"`
+		Host string `bson:"host"`
+	} `bson:"shards"`
+}
+
+type shardingStatus struct {
+	Sharding struct {
+		ConfigsvrConnectionString string `bson:"configsvrConnectionString"`
+	} `bson:"sharding"`
+}
+
+type balancerStatus struct {
+	Mode            string `bson:"mode"`
+	InBalancerRound bool   `bson:"inBalancerRound"`
+}
+
+func isMongos(hello bson.M) bool {
+	msg, ok := hello["msg"].(string)
+	return ok && msg == MongosMsg
+}
+
+// parseReplicaSet parses connection string <set name>/<host1>,<host2>
+func parseReplicaSet(shard, connstr string) (*replicaSet, error) {
+	parts := strings.SplitN(connstr, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("shard %s is not a replica set: %s", shard, connstr)
+	}
+	return &replicaSet{
+		shard:   shard,
+		setName: parts[0],
+		hosts:   strings.Split(parts[1], ","),
+	}, nil
+}
+
+// getReplicaSets returns replica sets of all shards and the config server
+func getReplicaSets(db *mongo.Database) ([]*replicaSet, error) {
+	shards := &shardList{}
+	err := db.RunCommand(context.TODO(), bson.D{{Key: "listShards", Value: 1}}).Decode(shards)
+	if err != nil {
+		log.Error(err, "failed to list shards")
+		return nil, err
+	}
+
+	var sets []*replicaSet
+	for _, shard := range shards.Shards {
+		rs, err := parseReplicaSet(shard.ID, shard.Host)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, rs)
+	}
+
+	status := &shardingStatus{}
+	err = db.RunCommand(context.TODO(), bson.D{{Key: "serverStatus", Value: 1}}).Decode(status)
+	if err != nil {
+		log.Error(err, "failed to get config server of sharded cluster")
+		return nil, err
+	}
+	rs, err := parseReplicaSet(ConfigServerShard, status.Sharding.ConfigsvrConnectionString)
+	if err != nil {
+		return nil, err
+	}
+	return append(sets, rs), nil
+}
+
+func getBalancerStatus(db *mongo.Database) (*balancerStatus, error) {
+	status := &balancerStatus{}
+	err := db.RunCommand(context.TODO(), bson.D{{Key: "balancerStatus", Value: 1}}).Decode(status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// stopBalancer stops the balancer and waits for the running balancer round,
+// chunk migrations are finished when the round is done
+func (mg *MG) stopBalancer(db *mongo.Database) error {
+	timeout := DefaultBalancerStopTimeout
+	result := db.RunCommand(context.TODO(), bson.D{{Key: "balancerStop", Value: 1}, {Key: "maxTimeMS", Value: timeout.Milliseconds()}})
+	if result.Err() != nil {
+		log.Error(result.Err(), "failed to stop balancer", "instance", mg.config.Name)
+		return result.Err()
+	}
+
+	log.Info("wait for balancer round finished", "instance", mg.config.Name)
+	err := wait.PollImmediate(BalancerPollingInterval, timeout, func() (bool, error) {
+		status, err := getBalancerStatus(db)
+		if err != nil {
+			return false, err
+		}
+		return !status.InBalancerRound, nil
+	})
+	if err != nil {
+		log.Error(err, "failed to wait for balancer stopped", "instance", mg.config.Name)
+		return err
+	}
+
+	log.Info("balancer stopped", "instance", mg.config.Name)
+	return nil
+}
+
+// startBalancer restarts the balancer unless it was off before quiesce
+func (mg *MG) startBalancer(db *mongo.Database, mode string) error {
+	if mode == BalancerModeOff {
+		log.Info("balancer was off before quiesce, skip starting", "instance", mg.config.Name)
+		return nil
+	}
+
+	result := db.RunCommand(context.TODO(), bson.D{{Key: "balancerStart", Value: 1}})
+	if result.Err() != nil {
+		log.Error(result.Err(), "failed to start balancer", "instance", mg.config.Name)
+		return result.Err()
+	}
+
+	log.Info("balancer started", "instance", mg.config.Name)
+	return nil
+}
+
+// selectMember picks a secondary of the replica set to lock, primary is only
+// selected if quiesce from primary is allowed
+func (mg *MG) selectMember(rs *replicaSet) (string, bool, error) {
+	primary := ""
+	for _, host := range rs.hosts {
+		var result bson.M
+		err := mg.runMemberCmd(host, func(db *mongo.Database) error {
+			return mg.runHello(db, nil, &result)
+		})
+		if err != nil {
+			log.Info("skip unreachable member", "member", host, "shard", rs.shard)
+			continue
+		}
+
+		if result["secondary"] == true {
+			return host, false, nil
+		}
+		if result["isWritablePrimary"] == true && primary == "" {
+			primary = host
+		}
+	}
+
+	if primary != "" && mg.config.QuiesceFromPrimary {
+		log.Info("no secondary found in replica set, quiesce from primary", "shard", rs.shard, "instance", mg.config.Name)
+		return primary, true, nil
+	}
+	return "", false, fmt.Errorf("no secondary found in replica set %s of shard %s", rs.setName, rs.shard)
+}
+
+// lockReplicaSet fsync locks one member of the replica set, fsync lock is
+// nested so the member is locked even if it's locked by others
+func (mg *MG) lockReplicaSet(rs *replicaSet) (*v1alpha1.MongoShardResult, error) {
+	member, primary, err := mg.selectMember(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	shardResult := &v1alpha1.MongoShardResult{
+		Shard:         rs.shard,
+		ReplicaSet:    rs.setName,
+		MongoEndpoint: member,
+		IsPrimary:     primary,
+	}
+
+	log.Info("quiesce mongo shard", "shard", rs.shard, "endpoint", member, "primary", primary)
+	err = mg.runMemberCmd(member, func(db *mongo.Database) error {
+		return db.RunCommand(context.TODO(), bson.D{{Key: "fsync", Value: 1}, {Key: "lock", Value: true}}).Err()
+	})
+	if err != nil {
+		log.Error(err, "failed to quiesce shard", "shard", rs.shard, "member", member)
+		return shardResult, err
+	}
+	return shardResult, nil
+}
+
+// unlockMembers releases the lock taken by quiesce on each locked member,
+// members failed to unlock are returned to retry
+func (mg *MG) unlockMembers(shards []v1alpha1.MongoShardResult) ([]v1alpha1.MongoShardResult, error) {
+	var remaining []v1alpha1.MongoShardResult
+	var lastErr error
+	for _, shard := range shards {
+		err := mg.runMemberCmd(shard.MongoEndpoint, func(db *mongo.Database) error {
+			result := db.RunCommand(context.TODO(), bson.D{{Key: "fsyncUnlock", Value: 1}})
+			// fsyncUnlock called when not locked
+			if result.Err() != nil && !strings.Contains(result.Err().Error(), "not locked") {
+				return result.Err()
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error(err, "failed to unquiesce shard member", "shard", shard.Shard, "member", shard.MongoEndpoint)
+			remaining = append(remaining, shard)
+			lastErr = err
+			continue
+		}
+		log.Info("mongo shard unquiesced", "shard", shard.Shard, "member", shard.MongoEndpoint)
+	}
+	return remaining, lastErr
+}
+
+// unlockReplicaSets unlocks all reachable members, it's used only if the
+// members locked by quiesce are unknown
+func (mg *MG) unlockReplicaSets(sets []*replicaSet) error {
+	var lastErr error
+	for _, rs := range sets {
+		for _, host := range rs.hosts {
+			err := mg.runMemberCmd(host, func(db *mongo.Database) error {
+				var result bson.M
+				err := mg.runHello(db, nil, &result)
+				if err != nil {
+					// member is down, skip it to unlock the others
+					log.Info("skip unreachable member", "member", host, "shard", rs.shard)
+					return nil
+				}
+				return unlockDB(db, nil)
+			})
+			if err != nil {
+				log.Error(err, "failed to unquiesce shard member", "shard", rs.shard, "member", host)
+				lastErr = err
+			}
+		}
+	}
+	return lastErr
+}
+
+func (mg *MG) quiesceSharded(db *mongo.Database) (*v1alpha1.QuiesceResult, error) {
+	mongoResult := &v1alpha1.MongoResult{
+		MongoEndpoint: mg.config.Host,
+	}
+	quiResult := &v1alpha1.QuiesceResult{Mongo: mongoResult}
+
+	sets, err := getReplicaSets(db)
+	if err != nil {
+		return quiResult, err
+	}
+
+	mg.locked = nil
+	mg.lockedKnown = true
+
+	err = mg.stopBalancer(db)
+	if err != nil {
+		_ = mg.startBalancer(db, mg.balancerMode)
+		return quiResult, err
+	}
+
+	for _, rs := range sets {
+		shardResult, err := mg.lockReplicaSet(rs)
+		if err != nil {
+			mg.locked, _ = mg.unlockMembers(mg.locked)
+			_ = mg.startBalancer(db, mg.balancerMode)
+			return quiResult, err
+		}
+		mg.locked = append(mg.locked, *shardResult)
+		mongoResult.Shards = append(mongoResult.Shards, *shardResult)
+	}
+
+	return quiResult, nil
+}
+
+func (mg *MG) unquiesceSharded(db *mongo.Database, prev *v1alpha1.PreservedConfig) error {
+	var unlockErr error
+	if mg.lockedKnown {
+		mg.locked, unlockErr = mg.unlockMembers(mg.locked)
+	} else {
+		log.Info("locked members are unknown, unlock all members of sharded cluster", "instance", mg.config.Name)
+		sets, err := getReplicaSets(db)
+		if err != nil {
+			return err
+		}
+		unlockErr = mg.unlockReplicaSets(sets)
+	}
+
+	mode := ""
+	if prev != nil {
+		mode = prev.Params[BalancerMode]
+	}
+	err := mg.startBalancer(db, mode)
+	if err != nil {
+		return err
+	}
+	return unlockErr
+}
+
+// RestoreResult takes the locked members from quiesce result saved in status,
+// they're unknown if the manager is restarted after quiesce
+func (mg *MG) RestoreResult(result *v1alpha1.QuiesceResult) {
+	if mg.lockedKnown || result == nil || result.Mongo == nil || len(result.Mongo.Shards) == 0 {
+		return
+	}
+	mg.locked = append([]v1alpha1.MongoShardResult{}, result.Mongo.Shards...)
+	mg.lockedKnown = true
+}
+
+// runMemberCmd runs fn on admin database of the member by direct connection
+func (mg *MG) runMemberCmd(member string, fn func(*mongo.Database) error) error {
+	client, err := getMemberClient(mg.config, member)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Disconnect(context.TODO())
+	}()
+	return fn(client.Database("admin"))
+}
+
+func getMemberClient(appConfig appconfig.Config, member string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), appconfig.ConnectionTimeout)
+	defer cancel()
+
+	host := fmt.Sprintf("mongodb://%s:%s@%s",
+		appConfig.Username,
+		appConfig.Password,
+		member)
+	clientOptions := options.Client().ApplyURI(host).SetDirect(true)
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		log.Error(err, fmt.Sprintf("failed to connect mongodb member %s", member))
+		return client, err
+	}
+	return client, nil
+}
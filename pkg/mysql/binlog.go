@@ -0,0 +1,90 @@
+package mysql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	amberappApi "github.com/jibudata/amberapp/api/v1alpha1"
+)
+
+const (
+	LogStatusQuery        = "SELECT SERVER_UUID, LOCAL FROM performance_schema.log_status;"
+	MasterStatusCmd       = "SHOW MASTER STATUS;"
+	BinaryLogStatusCmd    = "SHOW BINARY LOG STATUS;"
+	ServerUUIDQuery       = "SELECT @@server_uuid;"
+	MariadbGtidQuery      = "SELECT @@gtid_binlog_pos;"
+	LogStatusMinVersion   = "8.0.14"
+	BinaryLogStatusMinVer = "8.2.0"
+)
+
+// logStatusLocal is the LOCAL column of performance_schema.log_status
+type logStatusLocal struct {
+	GtidExecuted      string `json:"gtid_executed"`
+	BinaryLogFile     string `json:"binary_log_file"`
+	BinaryLogPosition int64  `json:"binary_log_position"`
+}
+
+// getBinlogStatus reads binary log coordinates on the lock session, it must be
+// called when the lock is held so that the coordinates match the snapshot
+func (m *MYSQL) getBinlogStatus(result *amberappApi.MysqlResult) error {
+	if !m.isMariaDB() && m.versionAtLeast(LogStatusMinVersion) {
+		err := m.getLogStatus(result)
+		if err == nil {
+			return nil
+		}
+		// log_status requires BACKUP_ADMIN privilege, fall back to show status
+		log.Log.Info("failed to query performance_schema.log_status, fallback to binary log status", "instance", m.config.Name, "err", err.Error())
+	}
+
+	cmd := MasterStatusCmd
+	if !m.isMariaDB() && m.versionAtLeast(BinaryLogStatusMinVer) {
+		cmd = BinaryLogStatusCmd
+	}
+
+	rows, err := m.session.QueryRows(cmd)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) == 0 {
+		log.Log.Info("binary log is disabled", "instance", m.config.Name)
+	} else {
+		result.BinlogFile = rows[0]["File"]
+		result.BinlogPosition, err = strconv.ParseInt(rows[0]["Position"], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid binary log position %s, err: %v", rows[0]["Position"], err)
+		}
+		result.GtidExecuted = strings.ReplaceAll(rows[0]["Executed_Gtid_Set"], "\n", "")
+	}
+
+	if m.isMariaDB() {
+		return m.session.QueryRow(MariadbGtidQuery, &result.GtidExecuted)
+	}
+	return m.session.QueryRow(ServerUUIDQuery, &result.ServerUUID)
+}
+
+func (m *MYSQL) getLogStatus(result *amberappApi.MysqlResult) error {
+	rows, err := m.session.QueryRows(LogStatusQuery)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("empty result from performance_schema.log_status")
+	}
+
+	local := &logStatusLocal{}
+	err = json.Unmarshal([]byte(rows[0]["LOCAL"]), local)
+	if err != nil {
+		return fmt.Errorf("invalid log_status %s, err: %v", rows[0]["LOCAL"], err)
+	}
+
+	result.BinlogFile = local.BinaryLogFile
+	result.BinlogPosition = local.BinaryLogPosition
+	result.GtidExecuted = strings.ReplaceAll(local.GtidExecuted, "\n", "")
+	result.ServerUUID = rows[0]["SERVER_UUID"]
+	return nil
+}
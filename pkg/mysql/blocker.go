@@ -0,0 +1,157 @@
+package mysql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	amberappApi "github.com/jibudata/amberapp/api/v1alpha1"
+)
+
+const (
+	DefaultLockWaitTimeout = 30 * time.Second
+	DefaultLongQueryTime   = 10 * time.Second
+	// extra time for server to return lock wait timeout before context deadline
+	LockDeadlineMargin = 5 * time.Second
+
+	LockWaitTimeoutCmd = "SET SESSION lock_wait_timeout = %d;"
+	KillQueryCmd       = "KILL QUERY %d;"
+	LongQueryQuery     = "SELECT ID, USER, HOST, DB, COMMAND, TIME, STATE, LEFT(INFO, 256) AS INFO FROM information_schema.processlist " +
+		"WHERE ID <> CONNECTION_ID() AND TIME >= %d AND INFO IS NOT NULL " +
+		"AND COMMAND NOT IN ('Sleep', 'Daemon', 'Binlog Dump', 'Binlog Dump GTID') " +
+		"AND USER NOT IN ('system user', 'event_scheduler')%s;"
+	// statements on other databases don't block the database lock
+	BlockerDatabaseFilter = " AND DB IN (%s)"
+)
+
+// getSecondsParam parses a param in seconds, default value is returned if not set
+func getSecondsParam(params map[string]string, key string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok := params[key]
+	if !ok || value == "" {
+		return defaultValue, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid param %s: %s, must be positive seconds", key, value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func getBlockerPolicy(params map[string]string) (string, error) {
+	policy, ok := params[amberappApi.BlockerPolicy]
+	if !ok || policy == "" {
+		return amberappApi.BlockerPolicyAbort, nil
+	}
+
+	switch policy {
+	case amberappApi.BlockerPolicyAbort, amberappApi.BlockerPolicyKill:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid param %s: %s", amberappApi.BlockerPolicy, policy)
+	}
+}
+
+// findBlockers lists long running statements which block the lock, during
+// lock waiting all new writes pile up behind the lock
+func (m *MYSQL) findBlockers() ([]amberappApi.MysqlBlocker, error) {
+	filter := ""
+	var args []interface{}
+	if m.lockMethod == amberappApi.MysqlDatabaseLock {
+		placeholders := make([]string, len(m.config.Databases))
+		for i, database := range m.config.Databases {
+			placeholders[i] = "?"
+			args = append(args, database)
+		}
+		filter = fmt.Sprintf(BlockerDatabaseFilter, strings.Join(placeholders, ", "))
+	}
+
+	rows, err := m.session.QueryRows(fmt.Sprintf(LongQueryQuery, int64(m.longQueryTime/time.Second), filter), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var blockers []amberappApi.MysqlBlocker
+	for _, row := range rows {
+		id, err := strconv.ParseInt(row["ID"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid process id %s, err: %v", row["ID"], err)
+		}
+		seconds, err := strconv.ParseInt(row["TIME"], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid process time %s, err: %v", row["TIME"], err)
+		}
+		blockers = append(blockers, amberappApi.MysqlBlocker{
+			ID:      id,
+			User:    row["USER"],
+			Host:    row["HOST"],
+			DB:      row["DB"],
+			Command: row["COMMAND"],
+			Time:    seconds,
+			State:   row["STATE"],
+			Info:    row["INFO"],
+		})
+	}
+	return blockers, nil
+}
+
+// waitsForStatements returns true if the lock method waits for running
+// statements to finish, database lock only waits for the locked databases
+func waitsForStatements(lockMethod string) bool {
+	switch lockMethod {
+	case amberappApi.MysqlTableLock, amberappApi.MysqlDatabaseLock, amberappApi.MysqlBackupStageLock, amberappApi.MysqlBackupLock:
+		return true
+	}
+	return false
+}
+
+// handleBlockers applies blocker policy before lock, the blockers are reported
+// in result
+func (m *MYSQL) handleBlockers() error {
+	if !m.checkBlockers || !waitsForStatements(m.lockMethod) {
+		return nil
+	}
+
+	blockers, err := m.findBlockers()
+	if err != nil {
+		return err
+	}
+	if len(blockers) == 0 {
+		return nil
+	}
+
+	if m.blockerPolicy != amberappApi.BlockerPolicyKill {
+		m.result.Blockers = blockers
+		return fmt.Errorf("found %d statements running longer than %v, abort quiesce", len(blockers), m.longQueryTime)
+	}
+
+	for i := range blockers {
+		log.Log.Info("kill mysql blocker", "id", blockers[i].ID, "user", blockers[i].User, "time", blockers[i].Time, "info", blockers[i].Info)
+		err = m.session.Exec(fmt.Sprintf(KillQueryCmd, blockers[i].ID))
+		if err != nil {
+			// the statement may be finished already
+			log.Log.Error(err, "failed to kill mysql blocker", "id", blockers[i].ID)
+			continue
+		}
+		blockers[i].Killed = true
+	}
+	m.result.Blockers = blockers
+	return nil
+}
+
+// lockWithDeadline runs lock command bounded by lock_wait_timeout and context deadline
+func (m *MYSQL) lockWithDeadline(cmd string) error {
+	err := m.session.Exec(fmt.Sprintf(LockWaitTimeoutCmd, int64(m.lockWaitTimeout/time.Second)))
+	if err != nil {
+		return err
+	}
+
+	err = m.session.ExecWithTimeout(cmd, m.lockWaitTimeout+LockDeadlineMargin)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock in %v by %s, err: %v", m.lockWaitTimeout, cmd, err)
+	}
+	return nil
+}
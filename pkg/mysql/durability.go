@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	InnodbFlushLogAtTrxCommit = "innodb_flush_log_at_trx_commit"
+	SyncBinlog                = "sync_binlog"
+
+	FlushEngineLogsCmd = "FLUSH ENGINE LOGS;"
+	FlushBinaryLogsCmd = "FLUSH BINARY LOGS;"
+	LogBinQuery        = "SELECT @@global.log_bin;"
+)
+
+// durability settings are turned to fully durable value during quiesce
+var DurabilityVariables = []string{InnodbFlushLogAtTrxCommit, SyncBinlog}
+
+// preserveDurability saves original durability settings before quiesce
+func (m *MYSQL) preserveDurability(preserved map[string]string) error {
+	db, err := m.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m.durability = make(map[string]string)
+	for _, name := range DurabilityVariables {
+		value, err := getGlobalVariable(db, name)
+		if err != nil {
+			return err
+		}
+		preserved[name] = value
+		m.durability[name] = value
+	}
+	return nil
+}
+
+// escalateDurability makes every commit durable and flushes the logs, so the
+// volume snapshot has all committed transactions on disk
+func (m *MYSQL) escalateDurability() error {
+	db, err := m.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, name := range DurabilityVariables {
+		log.Log.Info("set mysql durability", "name", name, "value", 1, "instance", m.config.Name)
+		_, err = db.Exec(fmt.Sprintf(SetGlobalVariableCmd, name, "1"))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec(FlushEngineLogsCmd)
+	if err != nil {
+		return err
+	}
+
+	var logBin string
+	err = db.QueryRow(LogBinQuery).Scan(&logBin)
+	if err != nil {
+		return err
+	}
+	if logBin == "1" || logBin == "ON" {
+		_, err = db.Exec(FlushBinaryLogsCmd)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Log.Info("mysql logs flushed", "instance", m.config.Name)
+	return nil
+}
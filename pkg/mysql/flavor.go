@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	amberappApi "github.com/jibudata/amberapp/api/v1alpha1"
+)
+
+const (
+	FlavorMySQL   = "mysql"
+	FlavorPercona = "percona"
+	FlavorMariaDB = "mariadb"
+
+	InstanceLockMinVersion = "8.0.0"
+
+	VersionQuery        = "SELECT VERSION(), @@version_comment;"
+	BackupLocksQuery    = "SHOW GLOBAL VARIABLES LIKE 'have_backup_locks';"
+	NonInnodbTableQuery = "SELECT COUNT(*) FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND engine <> 'InnoDB' AND table_schema NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys');"
+)
+
+// detectServer gets version and flavor of the mysql server
+func (m *MYSQL) detectServer(db *sql.DB) error {
+	var version, comment string
+	err := db.QueryRow(VersionQuery).Scan(&version, &comment)
+	if err != nil {
+		log.Log.Error(err, "could not get mysql version")
+		return err
+	}
+	m.version = version
+
+	lower := strings.ToLower(version + " " + comment)
+	switch {
+	case strings.Contains(lower, "mariadb"):
+		m.flavor = FlavorMariaDB
+	case strings.Contains(lower, "percona"):
+		m.flavor = FlavorPercona
+	default:
+		m.flavor = FlavorMySQL
+	}
+
+	m.backupLocks = false
+	if m.flavor == FlavorPercona {
+		var name, value string
+		err = db.QueryRow(BackupLocksQuery).Scan(&name, &value)
+		if err != nil && err != sql.ErrNoRows {
+			log.Log.Error(err, "could not get percona backup locks support")
+			return err
+		}
+		m.backupLocks = strings.EqualFold(value, "YES")
+	}
+
+	log.Log.Info("get mysql version", "version", version, "flavor", m.flavor, "backup locks", m.backupLocks, "instance", m.config.Name)
+	return nil
+}
+
+func (m *MYSQL) hasNonInnodbTables(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(NonInnodbTableQuery).Scan(&count)
+	if err != nil {
+		log.Log.Error(err, "could not count non-InnoDB tables")
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// resolveLockMethod returns the lock method to quiesce the server, the method
+// in params is checked against the server, or selected if it is auto
+func (m *MYSQL) resolveLockMethod(db *sql.DB) (string, error) {
+	method := m.getLockMethod()
+	if method == amberappApi.MysqlAutoLock {
+		nonInnodb, err := m.hasNonInnodbTables(db)
+		if err != nil {
+			return "", err
+		}
+		method = m.selectLockMethod(nonInnodb)
+		log.Log.Info("select mysql lock method", "method", method, "non-InnoDB tables", nonInnodb, "instance", m.config.Name)
+	}
+
+	return method, m.checkLockMethod(method)
+}
+
+// selectLockMethod picks the lightest lock which still gives a consistent
+// snapshot, InnoDB tables are recovered from redo log, other tables must be
+// blocked from writes
+func (m *MYSQL) selectLockMethod(nonInnodb bool) string {
+	switch m.flavor {
+	case FlavorMariaDB:
+		if m.versionAtLeast(BackupStageMinVersion) {
+			return amberappApi.MysqlBackupStageLock
+		}
+	case FlavorPercona:
+		if !nonInnodb && m.versionAtLeast(InstanceLockMinVersion) {
+			return amberappApi.MysqlInstanceLock
+		}
+		if m.backupLocks {
+			return amberappApi.MysqlBackupLock
+		}
+	default:
+		if !nonInnodb && m.versionAtLeast(InstanceLockMinVersion) {
+			return amberappApi.MysqlInstanceLock
+		}
+	}
+
+	return amberappApi.MysqlTableLock
+}
+
+func (m *MYSQL) checkLockMethod(method string) error {
+	switch method {
+	case amberappApi.MysqlInstanceLock:
+		if m.flavor == FlavorMariaDB || !m.versionAtLeast(InstanceLockMinVersion) {
+			return fmt.Errorf("lock method %s requires MySQL >= %s, current version: %s", method, InstanceLockMinVersion, m.version)
+		}
+	case amberappApi.MysqlBackupStageLock:
+		if m.flavor != FlavorMariaDB || !m.versionAtLeast(BackupStageMinVersion) {
+			return fmt.Errorf("lock method %s requires MariaDB >= %s, current version: %s", method, BackupStageMinVersion, m.version)
+		}
+	case amberappApi.MysqlBackupLock:
+		if m.flavor != FlavorPercona || !m.backupLocks {
+			return fmt.Errorf("lock method %s requires Percona Server with backup locks, current version: %s", method, m.version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	WsrepDesync = "wsrep_desync"
+
+	WsrepOnQuery          = "SHOW GLOBAL VARIABLES LIKE 'wsrep_on';"
+	WsrepLocalStateQuery  = "SHOW GLOBAL STATUS LIKE 'wsrep_local_state_comment';"
+	WsrepDesyncedState    = "Donor/Desynced"
+	DefaultDesyncTimeout  = 3 * time.Minute
+	DesyncPollingInterval = 1 * time.Second
+)
+
+// isGaleraNode checks if the server is a galera or percona xtradb cluster node
+func isGaleraNode(db *sql.DB) (bool, error) {
+	var name, value string
+	err := db.QueryRow(WsrepOnQuery).Scan(&name, &value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		log.Log.Error(err, "could not get wsrep_on")
+		return false, err
+	}
+	return strings.EqualFold(value, "ON"), nil
+}
+
+// desyncNode takes the node out of flow control before it is locked, so the
+// lock doesn't stall the whole cluster
+func (m *MYSQL) desyncNode() error {
+	log.Log.Info("desync galera node", "instance", m.config.Name)
+	err := m.session.Exec(fmt.Sprintf(SetGlobalVariableCmd, WsrepDesync, "ON"))
+	if err != nil {
+		return err
+	}
+
+	var state string
+	err = wait.PollImmediate(DesyncPollingInterval, DefaultDesyncTimeout, func() (bool, error) {
+		var name string
+		err := m.session.QueryRow(WsrepLocalStateQuery, &name, &state)
+		if err != nil {
+			return false, err
+		}
+		return state == WsrepDesyncedState, nil
+	})
+	if err != nil {
+		return fmt.Errorf("galera node is not desynced, state: %s, err: %v", state, err)
+	}
+
+	log.Log.Info("galera node desynced", "instance", m.config.Name)
+	return nil
+}
@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	GroupMembersQuery   = "SELECT MEMBER_ID, MEMBER_HOST, MEMBER_PORT, MEMBER_STATE FROM performance_schema.replication_group_members;"
+	GroupRolesQuery     = "SELECT MEMBER_ID, MEMBER_ROLE FROM performance_schema.replication_group_members;"
+	GroupPrimaryQuery   = "SELECT VARIABLE_VALUE FROM performance_schema.global_status WHERE VARIABLE_NAME = 'group_replication_primary_member';"
+	GroupRoleMinVersion = "8.0.2"
+	GroupMinVersion     = "5.7.17"
+
+	// group member quiesced, saved by prepare and used by unquiesce
+	GroupMember = "group_member"
+
+	MemberOnline    = "ONLINE"
+	MemberOffline   = "OFFLINE"
+	MemberPrimary   = "PRIMARY"
+	MemberSecondary = "SECONDARY"
+)
+
+type groupMember struct {
+	id    string
+	host  string
+	port  string
+	state string
+	role  string
+}
+
+func (g *groupMember) endpoint() string {
+	return net.JoinHostPort(g.host, g.port)
+}
+
+// getGroupMembers returns members of group replication, empty if the server
+// is not a group member
+func (m *MYSQL) getGroupMembers(db *sql.DB) ([]*groupMember, error) {
+	if m.isMariaDB() || !m.versionAtLeast(GroupMinVersion) {
+		return nil, nil
+	}
+
+	rows, err := db.Query(GroupMembersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := session.ScanRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// plugin installed but group replication isn't running
+	if len(result) == 1 && (result[0]["MEMBER_HOST"] == "" || result[0]["MEMBER_STATE"] == MemberOffline) {
+		return nil, nil
+	}
+
+	members := make(map[string]*groupMember)
+	var list []*groupMember
+	for _, row := range result {
+		member := &groupMember{
+			id:    row["MEMBER_ID"],
+			host:  row["MEMBER_HOST"],
+			port:  row["MEMBER_PORT"],
+			state: row["MEMBER_STATE"],
+		}
+		members[member.id] = member
+		list = append(list, member)
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	err = m.getGroupRoles(db, members)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m *MYSQL) getGroupRoles(db *sql.DB, members map[string]*groupMember) error {
+	if m.versionAtLeast(GroupRoleMinVersion) {
+		rows, err := db.Query(GroupRolesQuery)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id, role string
+			err = rows.Scan(&id, &role)
+			if err != nil {
+				return err
+			}
+			if member, ok := members[id]; ok {
+				member.role = role
+			}
+		}
+		return rows.Err()
+	}
+
+	// 5.7 reports primary member id only in single primary mode
+	var primary string
+	err := db.QueryRow(GroupPrimaryQuery).Scan(&primary)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for id, member := range members {
+		if primary == "" || id == primary {
+			member.role = MemberPrimary
+		} else {
+			member.role = MemberSecondary
+		}
+	}
+	return nil
+}
+
+// selectGroupMember picks an online secondary to quiesce, primary is only
+// selected if quiesce from primary is allowed
+func (m *MYSQL) selectGroupMember(members []*groupMember) (*groupMember, error) {
+	var primary *groupMember
+	for _, member := range members {
+		if member.state != MemberOnline {
+			continue
+		}
+		if member.role == MemberSecondary {
+			return member, nil
+		}
+		if member.role == MemberPrimary && primary == nil {
+			primary = member
+		}
+	}
+
+	if primary != nil && m.config.QuiesceFromPrimary {
+		log.Log.Info("no online secondary in mysql group, quiesce from primary", "instance", m.config.Name)
+		return primary, nil
+	}
+	return nil, fmt.Errorf("no online secondary found in mysql group of %s", m.config.Host)
+}
+
+// resolveTarget selects the group member to quiesce if the endpoint is a
+// group replication member, otherwise the endpoint is quiesced
+func (m *MYSQL) resolveTarget(db *sql.DB) error {
+	m.target = ""
+	m.targetPrimary = false
+
+	members, err := m.getGroupMembers(db)
+	if err != nil {
+		log.Log.Error(err, "could not get mysql group replication members", "instance", m.config.Name)
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	member, err := m.selectGroupMember(members)
+	if err != nil {
+		log.Log.Error(err, "could not select mysql group member", "instance", m.config.Name)
+		return err
+	}
+
+	m.target = member.endpoint()
+	m.targetPrimary = member.role == MemberPrimary
+	log.Log.Info("select mysql group member to quiesce", "member", m.target, "primary", m.targetPrimary, "instance", m.config.Name)
+	return nil
+}
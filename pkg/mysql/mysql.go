@@ -3,38 +3,100 @@ package mysql
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"golang.org/x/mod/semver"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	amberappApi "github.com/jibudata/amberapp/api/v1alpha1"
 	"github.com/jibudata/amberapp/pkg/appconfig"
+	"github.com/jibudata/amberapp/pkg/session"
 )
 
 const (
+	ConnectionIDQuery = "SELECT CONNECTION_ID();"
+
 	TableLockCmd      = "FLUSH TABLES WITH READ LOCK;"
 	TableUnLockCmd    = "UNLOCK TABLES;"
 	InstanceLockCmd   = "LOCK INSTANCE FOR BACKUP;"
 	InstanceUnLockCmd = "UNLOCK INSTANCE;"
+	BackupLockCmd     = "LOCK TABLES FOR BACKUP;"
+	BackupStageCmd    = "BACKUP STAGE %s;"
+	BackupStageEnd    = "END"
+
+	BackupStageMinVersion = "10.4.1"
 )
 
+// mariadb backup stages to block all writes
+var BackupStages = []string{"START", "FLUSH", "BLOCK_DDL", "BLOCK_COMMIT"}
+
+// global variables saved by prepare and restored by unquiesce in order,
+// super_read_only must be restored before read_only
+var PreservedVariables = []string{WsrepDesync, SuperReadOnly, ReadOnly, InnodbFlushLogAtTrxCommit, SyncBinlog}
+
 type MYSQL struct {
-	config appconfig.Config
-	db     *sql.DB
+	config      appconfig.Config
+	version     string
+	flavor      string
+	backupLocks bool
+	lockMethod  string
+	galera      bool
+	desync      string
+	// group member to quiesce, endpoint is quiesced if empty
+	target        string
+	targetPrimary bool
+	targetErr     error
+
+	// escalate durability during quiesce, original settings are saved by prepare
+	durable    bool
+	durability map[string]string
+
+	lockWaitTimeout time.Duration
+	// blockers are checked only if blocker params are set
+	checkBlockers bool
+	longQueryTime time.Duration
+	blockerPolicy string
+
+	// the lock is held by the session until unquiesce
+	session      *session.Session
+	lockedMethod string
+	result       *amberappApi.MysqlResult
 }
 
 func (m *MYSQL) Init(appConfig appconfig.Config) error {
-	m.config = appConfig
-	if m.db != nil {
-		m.db.Close()
+	if m.session != nil {
+		err := fmt.Errorf("mysql %s is quiesced, cannot init with new config", m.config.Name)
+		log.Log.Error(err, "")
+		return err
 	}
-	m.db = nil
+	m.config = appConfig
 	dbs := m.config.Databases
 	if len(dbs) == 0 {
 		err := fmt.Errorf("no database specified in %s", m.config.Name)
 		log.Log.Error(err, "")
 		return err
 	}
+
+	var err error
+	m.lockWaitTimeout, err = getSecondsParam(m.config.Params, amberappApi.LockWaitTimeout, DefaultLockWaitTimeout)
+	if err != nil {
+		log.Log.Error(err, "", "instance", m.config.Name)
+		return err
+	}
+	m.longQueryTime, err = getSecondsParam(m.config.Params, amberappApi.LongQuerySeconds, DefaultLongQueryTime)
+	if err != nil {
+		log.Log.Error(err, "", "instance", m.config.Name)
+		return err
+	}
+	m.blockerPolicy, err = getBlockerPolicy(m.config.Params)
+	if err != nil {
+		log.Log.Error(err, "", "instance", m.config.Name)
+		return err
+	}
+	m.checkBlockers = m.config.Params[amberappApi.LongQuerySeconds] != "" || m.config.Params[amberappApi.BlockerPolicy] != ""
+	m.durable = m.config.Params[amberappApi.DurableFlush] == "true"
 	return nil
 }
 
@@ -47,9 +109,8 @@ func (m *MYSQL) Connect() error {
 		log.Log.Error(err, "")
 		return err
 	}
-	for _, database := range dbs {
-		dsn := fmt.Sprintf("%s:%s@%s(%s)/%s", m.config.Username, m.config.Password, "tcp", m.config.Host, database)
-		db, err := sql.Open("mysql", dsn)
+	for i, database := range dbs {
+		db, err := sql.Open("mysql", m.getDSN(database))
 		if err != nil {
 			log.Log.Error(err, fmt.Sprintf("failed to init connection to mysql database %s, in %s", database, m.config.Name))
 			return err
@@ -59,13 +120,94 @@ func (m *MYSQL) Connect() error {
 			log.Log.Error(err, fmt.Sprintf("cannot access mysql databases %s in %s", database, m.config.Name))
 			return err
 		}
+		if i == 0 {
+			err = m.detectServer(db)
+			if err != nil {
+				db.Close()
+				return err
+			}
+			m.lockMethod, err = m.resolveLockMethod(db)
+			if err != nil {
+				log.Log.Error(err, "", "instance", m.config.Name)
+				db.Close()
+				return err
+			}
+			m.galera, err = isGaleraNode(db)
+			if err != nil {
+				db.Close()
+				return err
+			}
+			// keep the member holding the lock, unquiesce uses the member
+			// saved by prepare, failure is reported by prepare
+			if m.session == nil {
+				m.targetErr = m.resolveTarget(db)
+			}
+		}
 		db.Close()
 	}
+
 	log.Log.Info("mysql connected")
 	return nil
 }
 
 func (m *MYSQL) Prepare() (*amberappApi.PreservedConfig, error) {
+	preserved := make(map[string]string)
+
+	if m.targetErr != nil {
+		return nil, m.targetErr
+	}
+	if m.target != "" {
+		preserved[GroupMember] = m.target
+	}
+
+	if m.getLockMethod() == amberappApi.MysqlAutoLock {
+		preserved[amberappApi.LockMethod] = m.lockMethod
+	}
+
+	if m.lockMethod == amberappApi.MysqlReplicaLock {
+		running, err := m.getReplicaSQLRunning()
+		if err != nil {
+			log.Log.Error(err, fmt.Sprintf("lock method %s is not supported", m.lockMethod), "instance", m.config.Name)
+			return nil, err
+		}
+		preserved[ReplicaSQLRunning] = running
+	}
+
+	if m.lockMethod == amberappApi.MysqlReadOnlyLock {
+		err := m.preserveReadOnly(preserved)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.durable {
+		err := m.preserveDurability(preserved)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.galera {
+		db, err := m.openDB()
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+
+		desync, err := getGlobalVariable(db, WsrepDesync)
+		if err != nil {
+			return nil, err
+		}
+		preserved[WsrepDesync] = desync
+		m.desync = desync
+	}
+
+	if len(preserved) > 0 {
+		log.Log.Info("mysql prepared", "params", preserved)
+		return &amberappApi.PreservedConfig{
+			Params: preserved,
+		}, nil
+	}
 	return nil, nil
 }
 
@@ -73,78 +215,308 @@ func (m *MYSQL) Quiesce() (*amberappApi.QuiesceResult, error) {
 	var err error
 	log.Log.Info("mysql quiesce in progress...")
 
-	dsn := fmt.Sprintf("%s:%s@%s(%s)/%s", m.config.Username, m.config.Password, "tcp", m.config.Host, m.config.Databases[0])
-	m.db, err = sql.Open("mysql", dsn)
+	if m.durable && m.session == nil {
+		err = m.escalateDurability()
+		if err != nil {
+			m.abortQuiesce()
+			return nil, err
+		}
+	}
+
+	if m.lockMethod == amberappApi.MysqlReplicaLock {
+		m.result = m.newResult()
+		err = m.stopReplicaApplier(m.result)
+		if err != nil {
+			m.abortQuiesce()
+		}
+		return &amberappApi.QuiesceResult{Mysql: m.result}, err
+	}
+
+	if m.session != nil {
+		err = m.session.Err()
+		if err == nil {
+			log.Log.Info("mysql already locked", "instance", m.config.Name, "session", m.session.ID())
+			return &amberappApi.QuiesceResult{Mysql: m.result}, nil
+		}
+		log.Log.Error(err, "drop lost mysql lock session", "instance", m.config.Name)
+		m.session.Close()
+		m.session = nil
+	}
+
+	m.session, err = session.New("mysql", m.getTargetDSN(m.config.Databases[0]), ConnectionIDQuery)
 	if err != nil {
 		log.Log.Error(err, fmt.Sprintf("failed to init connection to mysql database %s, in %s", m.config.Databases[0], m.config.Name))
 		return nil, err
 	}
 
-	return nil, m.mysqlLock()
+	m.result = m.newResult()
+
+	if m.galera {
+		err = m.desyncNode()
+		if err != nil {
+			m.abortQuiesce()
+			return &amberappApi.QuiesceResult{Mysql: m.result}, err
+		}
+	}
+
+	err = m.mysqlLock()
+	if err != nil {
+		m.abortQuiesce()
+		return &amberappApi.QuiesceResult{Mysql: m.result}, err
+	}
+
+	err = m.getBinlogStatus(m.result)
+	if err != nil {
+		log.Log.Error(err, "failed to get mysql binary log status", "instance", m.config.Name)
+	}
+	log.Log.Info("mysql binary log status", "file", m.result.BinlogFile, "position", m.result.BinlogPosition, "gtid", m.result.GtidExecuted)
+
+	// read only is kept by server, no need to hold the session
+	if m.lockMethod == amberappApi.MysqlReadOnlyLock {
+		m.session.Close()
+		m.session = nil
+	}
+
+	return &amberappApi.QuiesceResult{Mysql: m.result}, nil
 }
 
 func (m *MYSQL) Unquiesce(prev *amberappApi.PreservedConfig) error {
 	log.Log.Info("mysql unquiesce in progress...")
-	return m.mysqlUnlock()
+	unlockErr := m.mysqlUnlock()
+	if unlockErr != nil {
+		log.Log.Error(unlockErr, "failed to unlock mysql", "instance", m.config.Name)
+	}
+
+	// settings are restored on the member quiesced, not the member selected now
+	m.target = ""
+	if prev != nil {
+		m.target = prev.Params[GroupMember]
+	}
+
+	// restore the settings even if the lock is lost
+	if prev != nil {
+		err := m.restoreGlobalVariables(PreservedVariables, prev.Params)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.lockMethod == amberappApi.MysqlReplicaLock {
+		err := m.startReplicaApplier(prev)
+		if err != nil {
+			return err
+		}
+	}
+
+	return unlockErr
+}
+
+func (m *MYSQL) newResult() *amberappApi.MysqlResult {
+	result := &amberappApi.MysqlResult{
+		LockMethod:     m.lockMethod,
+		MemberEndpoint: m.config.Host,
+		IsPrimary:      m.targetPrimary,
+	}
+	if m.target != "" {
+		result.MemberEndpoint = m.target
+	}
+	return result
+}
+
+// abortQuiesce releases the lock session and reverts galera desync and durability
+func (m *MYSQL) abortQuiesce() {
+	if m.session != nil {
+		m.session.Close()
+		m.session = nil
+	}
+
+	if m.galera && m.desync != "" {
+		err := m.restoreGlobalVariables([]string{WsrepDesync}, map[string]string{WsrepDesync: m.desync})
+		if err != nil {
+			log.Log.Error(err, "failed to revert galera desync", "instance", m.config.Name)
+		}
+	}
+
+	if m.durable && m.durability != nil {
+		err := m.restoreGlobalVariables(DurabilityVariables, m.durability)
+		if err != nil {
+			log.Log.Error(err, "failed to revert durability", "instance", m.config.Name)
+		}
+	}
+}
+
+// IsSessionHeld returns true if the lock session is kept open
+func (m *MYSQL) IsSessionHeld() bool {
+	return m.session != nil
+}
+
+// CheckSession returns error if the lock session is lost
+func (m *MYSQL) CheckSession() error {
+	if m.session == nil {
+		return nil
+	}
+	return m.session.Err()
 }
 
 func (m *MYSQL) mysqlLock() error {
-	cmd := m.getLockCmd()
-	_, err := m.db.Exec(cmd)
-	return err
+	var err error
+	m.lockedMethod = m.lockMethod
+
+	err = m.handleBlockers()
+	if err != nil {
+		return err
+	}
+
+	switch m.lockMethod {
+	case amberappApi.MysqlBackupStageLock:
+		for _, stage := range BackupStages {
+			err = m.lockWithDeadline(fmt.Sprintf(BackupStageCmd, stage))
+			if err != nil {
+				return fmt.Errorf("failed to run backup stage %s after stage %s, err: %v", stage, m.result.BackupStage, err)
+			}
+			m.result.BackupStage = stage
+		}
+	case amberappApi.MysqlReadOnlyLock:
+		err = m.lockWithDeadline(fmt.Sprintf(SetGlobalVariableCmd, m.readOnlyVariables()[0], "ON"))
+		if err != nil {
+			return err
+		}
+	case amberappApi.MysqlDatabaseLock:
+		cmd, tables, err := m.getScopedLockCmd()
+		if err != nil {
+			return err
+		}
+		err = m.lockWithDeadline(cmd)
+		if err != nil {
+			return err
+		}
+		m.result.LockedTables = tables
+	default:
+		err = m.lockWithDeadline(getLockCmd(m.lockMethod))
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.lockMethod == amberappApi.MysqlReadOnlyLock {
+		log.Log.Info("mysql set to read only", "instance", m.config.Name)
+		return nil
+	}
+
+	// make sure the lock is taken by the pinned session before reporting quiesced
+	err = m.session.Check()
+	if err != nil {
+		return err
+	}
+
+	m.session.Keepalive()
+	log.Log.Info("mysql locked", "instance", m.config.Name, "method", m.lockMethod, "session", m.session.ID())
+	return nil
 }
 
 func (m *MYSQL) mysqlUnlock() error {
-	if m.db == nil {
+	if m.session == nil {
 		return nil
 	}
-	cmd := m.getUnLockCmd()
-	_, err := m.db.Exec(cmd)
+	defer func() {
+		m.session.Close()
+		m.session = nil
+	}()
+
+	// the lock was released by server together with the lost session
+	err := m.session.Err()
 	if err != nil {
 		return err
 	}
 
-	return m.db.Close()
+	cmd := getUnLockCmd(m.lockedMethod)
+	err = m.session.Exec(cmd)
+	if err != nil {
+		// connection broken since last keepalive, lock is released by server
+		checkErr := m.session.Check()
+		if checkErr != nil {
+			return checkErr
+		}
+		return err
+	}
+
+	log.Log.Info("mysql unlocked", "instance", m.config.Name, "session", m.session.ID())
+	return nil
 }
 
-func (m *MYSQL) getLockCmd() string {
-	if m.config.Params == nil {
-		// default table lock
-		return TableLockCmd
+func (m *MYSQL) getDSN(database string) string {
+	return fmt.Sprintf("%s:%s@%s(%s)/%s", m.config.Username, m.config.Password, "tcp", m.config.Host, database)
+}
+
+// getTargetDSN returns dsn of the server to quiesce
+func (m *MYSQL) getTargetDSN(database string) string {
+	if m.target == "" {
+		return m.getDSN(database)
 	}
+	return fmt.Sprintf("%s:%s@%s(%s)/%s", m.config.Username, m.config.Password, "tcp", m.target, database)
+}
 
-	lockMethod, ok := m.config.Params[amberappApi.LockMethod]
-	if ok {
-		switch lockMethod {
-		case amberappApi.MysqlTableLock:
-			return TableLockCmd
-		case amberappApi.MysqlInstanceLock:
-			return InstanceLockCmd
-		default:
-			return TableLockCmd
-		}
+// openDB opens connection to the server to quiesce
+func (m *MYSQL) openDB() (*sql.DB, error) {
+	db, err := sql.Open("mysql", m.getTargetDSN(m.config.Databases[0]))
+	if err != nil {
+		log.Log.Error(err, fmt.Sprintf("failed to init connection to mysql database %s, in %s", m.config.Databases[0], m.config.Name))
+		return nil, err
 	}
+	return db, nil
+}
 
-	return TableLockCmd
+func (m *MYSQL) isMariaDB() bool {
+	return m.flavor == FlavorMariaDB
 }
 
-func (m *MYSQL) getUnLockCmd() string {
+// versionAtLeast compares the numeric part of server version, such as 8.0.33-log
+func (m *MYSQL) versionAtLeast(version string) bool {
+	current := "v" + strings.SplitN(m.version, "-", 2)[0]
+	return semver.Compare(current, "v"+version) >= 0
+}
+
+func (m *MYSQL) getLockMethod() string {
 	if m.config.Params == nil {
 		// default table lock
-		return TableUnLockCmd
+		return amberappApi.MysqlTableLock
 	}
 
 	lockMethod, ok := m.config.Params[amberappApi.LockMethod]
 	if ok {
 		switch lockMethod {
-		case amberappApi.MysqlTableLock:
-			return TableUnLockCmd
-		case amberappApi.MysqlInstanceLock:
-			return InstanceUnLockCmd
+		case amberappApi.MysqlTableLock, amberappApi.MysqlInstanceLock, amberappApi.MysqlBackupStageLock,
+			amberappApi.MysqlBackupLock, amberappApi.MysqlAutoLock, amberappApi.MysqlReplicaLock,
+			amberappApi.MysqlDatabaseLock, amberappApi.MysqlReadOnlyLock:
+			return lockMethod
 		default:
-			return TableUnLockCmd
+			return amberappApi.MysqlTableLock
 		}
 	}
 
-	return TableUnLockCmd
+	return amberappApi.MysqlTableLock
+}
+
+func getLockCmd(method string) string {
+	switch method {
+	case amberappApi.MysqlInstanceLock:
+		return InstanceLockCmd
+	case amberappApi.MysqlBackupLock:
+		return BackupLockCmd
+	case amberappApi.MysqlBackupStageLock:
+		return fmt.Sprintf(BackupStageCmd, BackupStages[len(BackupStages)-1])
+	default:
+		return TableLockCmd
+	}
+}
+
+func getUnLockCmd(method string) string {
+	switch method {
+	case amberappApi.MysqlInstanceLock:
+		return InstanceUnLockCmd
+	case amberappApi.MysqlBackupStageLock:
+		return fmt.Sprintf(BackupStageCmd, BackupStageEnd)
+	default:
+		return TableUnLockCmd
+	}
 }
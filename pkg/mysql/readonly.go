@@ -0,0 +1,36 @@
+package mysql
+
+const (
+	SuperReadOnly = "super_read_only"
+	ReadOnly      = "read_only"
+
+	SuperReadOnlyMinVersion = "5.7.8"
+)
+
+// readOnlyVariables returns variables to make server read only, the first one
+// is turned on by quiesce, super_read_only also turns on read_only
+func (m *MYSQL) readOnlyVariables() []string {
+	if m.isMariaDB() || !m.versionAtLeast(SuperReadOnlyMinVersion) {
+		return []string{ReadOnly}
+	}
+	return []string{SuperReadOnly, ReadOnly}
+}
+
+// preserveReadOnly saves original read only settings before quiesce, they are
+// restored by unquiesce even if controller restarted
+func (m *MYSQL) preserveReadOnly(preserved map[string]string) error {
+	db, err := m.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, name := range m.readOnlyVariables() {
+		value, err := getGlobalVariable(db, name)
+		if err != nil {
+			return err
+		}
+		preserved[name] = value
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	amberappApi "github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	ReplicaSQLRunning = "replica_sql_running"
+
+	// >= 8.0.22, mariadb >= 10.5.1
+	ReplicaStatusCmd     = "SHOW REPLICA STATUS;"
+	StopReplicaSQLCmd    = "STOP REPLICA SQL_THREAD;"
+	StartReplicaSQLCmd   = "START REPLICA SQL_THREAD;"
+	ReplicaMinVersion    = "8.0.22"
+	MariadbReplicaMinVer = "10.5.1"
+	// < 8.0.22
+	SlaveStatusCmd   = "SHOW SLAVE STATUS;"
+	StopSlaveSQLCmd  = "STOP SLAVE SQL_THREAD;"
+	StartSlaveSQLCmd = "START SLAVE SQL_THREAD;"
+
+	MariadbSlaveGtidQuery = "SELECT @@gtid_slave_pos;"
+
+	DefaultRelayLogDrainTimeout = 3 * time.Minute
+	RelayLogPollingInterval     = 1 * time.Second
+)
+
+// useReplicaSyntax returns true if server supports REPLICA instead of SLAVE
+func (m *MYSQL) useReplicaSyntax() bool {
+	if m.isMariaDB() {
+		return m.versionAtLeast(MariadbReplicaMinVer)
+	}
+	return m.versionAtLeast(ReplicaMinVersion)
+}
+
+func (m *MYSQL) getReplicaStatus(db *sql.DB) (map[string]string, error) {
+	cmd := SlaveStatusCmd
+	if m.useReplicaSyntax() {
+		cmd = ReplicaStatusCmd
+	}
+
+	rows, err := db.Query(cmd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result, err := session.ScanRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("mysql %s is not a replica", m.config.Host)
+	}
+	// multi-source replication is not supported
+	if len(result) > 1 {
+		return nil, fmt.Errorf("mysql %s has %d replication channels, only single source is supported", m.config.Host, len(result))
+	}
+	return result[0], nil
+}
+
+// replicaField returns the field of replica status, columns were renamed from
+// master/slave to source/replica since 8.0.22
+func replicaField(status map[string]string, names ...string) string {
+	for _, name := range names {
+		if value, ok := status[name]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func isRelayLogDrained(status map[string]string) bool {
+	readFile := replicaField(status, "Source_Log_File", "Master_Log_File")
+	execFile := replicaField(status, "Relay_Source_Log_File", "Relay_Master_Log_File")
+	readPos := replicaField(status, "Read_Source_Log_Pos", "Read_Master_Log_Pos")
+	execPos := replicaField(status, "Exec_Source_Log_Pos", "Exec_Master_Log_Pos")
+	return readFile == execFile && readPos == execPos
+}
+
+// stopReplicaApplier waits for the relay log drained and stops the sql thread,
+// the executed source position is recorded in result
+func (m *MYSQL) stopReplicaApplier(result *amberappApi.MysqlResult) error {
+	db, err := m.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	log.Log.Info("wait for mysql relay log drained", "instance", m.config.Name)
+	err = wait.PollImmediate(RelayLogPollingInterval, DefaultRelayLogDrainTimeout, func() (bool, error) {
+		status, err := m.getReplicaStatus(db)
+		if err != nil {
+			return false, err
+		}
+		return isRelayLogDrained(status), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		// source keeps writing, the executed position is still exact
+		log.Log.Info("timeout to wait relay log drained, stop applier anyway", "instance", m.config.Name)
+	} else if err != nil {
+		return err
+	}
+
+	cmd := StopSlaveSQLCmd
+	if m.useReplicaSyntax() {
+		cmd = StopReplicaSQLCmd
+	}
+	_, err = db.Exec(cmd)
+	if err != nil {
+		return err
+	}
+	log.Log.Info("mysql replica sql thread stopped", "instance", m.config.Name)
+
+	status, err := m.getReplicaStatus(db)
+	if err != nil {
+		return err
+	}
+
+	result.SourceLogFile = replicaField(status, "Relay_Source_Log_File", "Relay_Master_Log_File")
+	pos := replicaField(status, "Exec_Source_Log_Pos", "Exec_Master_Log_Pos")
+	result.SourceLogPosition, err = strconv.ParseInt(pos, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid executed source position %s, err: %v", pos, err)
+	}
+
+	if m.isMariaDB() {
+		return db.QueryRow(MariadbSlaveGtidQuery).Scan(&result.GtidExecuted)
+	}
+	result.GtidExecuted = strings.ReplaceAll(status["Executed_Gtid_Set"], "\n", "")
+	return nil
+}
+
+// startReplicaApplier restarts the sql thread if it was running before quiesce
+func (m *MYSQL) startReplicaApplier(prev *amberappApi.PreservedConfig) error {
+	if prev != nil {
+		running, ok := prev.Params[ReplicaSQLRunning]
+		if ok && running != "Yes" {
+			log.Log.Info("mysql replica sql thread was not running before quiesce, skip starting", "instance", m.config.Name)
+			return nil
+		}
+	}
+
+	db, err := m.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cmd := StartSlaveSQLCmd
+	if m.useReplicaSyntax() {
+		cmd = StartReplicaSQLCmd
+	}
+	_, err = db.Exec(cmd)
+	if err != nil {
+		return err
+	}
+
+	log.Log.Info("mysql replica sql thread started", "instance", m.config.Name)
+	return nil
+}
+
+// getReplicaSQLRunning returns replica sql thread state, error if the server
+// is not a replica
+func (m *MYSQL) getReplicaSQLRunning() (string, error) {
+	db, err := m.openDB()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	status, err := m.getReplicaStatus(db)
+	if err != nil {
+		return "", err
+	}
+	return replicaField(status, "Replica_SQL_Running", "Slave_SQL_Running"), nil
+}
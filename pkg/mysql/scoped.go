@@ -0,0 +1,43 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ScopedTablesQuery = "SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema IN (%s) ORDER BY TABLE_SCHEMA, TABLE_NAME;"
+	ScopedLockCmd     = "FLUSH TABLES %s WITH READ LOCK;"
+)
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// getScopedLockCmd enumerates tables of the databases in spec, only these
+// tables are locked so that other databases on the server keep writing
+func (m *MYSQL) getScopedLockCmd() (string, []string, error) {
+	placeholders := make([]string, len(m.config.Databases))
+	args := make([]interface{}, len(m.config.Databases))
+	for i, database := range m.config.Databases {
+		placeholders[i] = "?"
+		args[i] = database
+	}
+
+	rows, err := m.session.QueryRows(fmt.Sprintf(ScopedTablesQuery, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(rows) == 0 {
+		return "", nil, fmt.Errorf("no table found in databases %v of %s", m.config.Databases, m.config.Name)
+	}
+
+	tables := make([]string, 0, len(rows))
+	quoted := make([]string, 0, len(rows))
+	for _, row := range rows {
+		tables = append(tables, fmt.Sprintf("%s.%s", row["TABLE_SCHEMA"], row["TABLE_NAME"]))
+		quoted = append(quoted, fmt.Sprintf("%s.%s", quoteIdentifier(row["TABLE_SCHEMA"]), quoteIdentifier(row["TABLE_NAME"])))
+	}
+
+	return fmt.Sprintf(ScopedLockCmd, strings.Join(quoted, ", ")), tables, nil
+}
@@ -0,0 +1,67 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	GlobalVariableQuery  = "SELECT @@global.%s;"
+	SetGlobalVariableCmd = "SET GLOBAL %s = %s;"
+)
+
+var variableValuePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// getGlobalVariable returns the raw value of a global variable, booleans are
+// returned as 0 or 1
+func getGlobalVariable(db *sql.DB, name string) (string, error) {
+	var value string
+	err := db.QueryRow(fmt.Sprintf(GlobalVariableQuery, name)).Scan(&value)
+	if err != nil {
+		log.Log.Error(err, "could not get mysql global variable", "name", name)
+		return "", err
+	}
+	return value, nil
+}
+
+func setGlobalVariableCmd(name, value string) (string, error) {
+	if !variableValuePattern.MatchString(value) {
+		return "", fmt.Errorf("invalid value %s of mysql global variable %s", value, name)
+	}
+	return fmt.Sprintf(SetGlobalVariableCmd, name, value), nil
+}
+
+// restoreGlobalVariables restores global variables saved by prepare in order,
+// it runs on a new connection since the lock session may be gone after
+// controller restart
+func (m *MYSQL) restoreGlobalVariables(names []string, values map[string]string) error {
+	var db *sql.DB
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		cmd, err := setGlobalVariableCmd(name, value)
+		if err != nil {
+			return err
+		}
+
+		if db == nil {
+			db, err = m.openDB()
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+		}
+
+		log.Log.Info("restore mysql global variable", "name", name, "value", value, "instance", m.config.Name)
+		_, err = db.Exec(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package pgbouncer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/appconfig"
+)
+
+const (
+	// admin console is a virtual database of pgbouncer
+	AdminDatabase = "pgbouncer"
+	DefaultPort   = "6432"
+
+	PauseCmd            = "PAUSE"
+	ResumeCmd           = "RESUME"
+	ShowDatabasesCmd    = "SHOW DATABASES;"
+	DefaultPauseTimeout = 3 * time.Minute
+)
+
+// database names accepted by admin console commands, names are not quoted
+var databaseNamePattern = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+
+type PgBouncer struct {
+	config appconfig.Config
+	result *v1alpha1.PgBouncerResult
+}
+
+func (p *PgBouncer) Init(appConfig appconfig.Config) error {
+	for _, dbname := range appConfig.Databases {
+		if !databaseNamePattern.MatchString(dbname) {
+			err := fmt.Errorf("invalid pgbouncer database name %s in %s", dbname, appConfig.Name)
+			log.Log.Error(err, "")
+			return err
+		}
+	}
+	p.config = appConfig
+	return nil
+}
+
+func (p *PgBouncer) Connect() error {
+	log.Log.Info("pgbouncer connecting")
+
+	db, err := p.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	databases, err := p.getDatabases(db)
+	if err != nil {
+		log.Log.Error(err, "cannot show pgbouncer databases", "instance", p.config.Name)
+		return err
+	}
+	for _, dbname := range p.config.Databases {
+		if _, ok := databases[dbname]; !ok {
+			return fmt.Errorf("database %s is not found in pgbouncer %s", dbname, p.config.Host)
+		}
+	}
+
+	log.Log.Info("connected to pgbouncer")
+	return nil
+}
+
+func (p *PgBouncer) Prepare() (*v1alpha1.PreservedConfig, error) {
+	return nil, nil
+}
+
+func (p *PgBouncer) Quiesce() (*v1alpha1.QuiesceResult, error) {
+	log.Log.Info("pgbouncer quiesce in progress...")
+
+	db, err := p.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	targets, err := p.getTargets(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no database found in pgbouncer %s", p.config.Host)
+	}
+
+	// PAUSE waits for all server connections released, spec timeout is the
+	// quiesce hold time
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPauseTimeout)
+	defer cancel()
+
+	for _, cmd := range getCmds(PauseCmd, targets) {
+		_, err = db.ExecContext(ctx, cmd)
+		if err != nil && !strings.Contains(err.Error(), "already") {
+			log.Log.Error(err, "could not pause pgbouncer", "cmd", cmd, "instance", p.config.Name)
+			// pause is in effect even if the command is cancelled
+			p.resume()
+			return nil, err
+		}
+	}
+
+	paused, err := p.checkPaused(db, targets, true)
+	if err != nil {
+		p.resume()
+		return nil, err
+	}
+
+	p.result = &v1alpha1.PgBouncerResult{PausedDatabases: paused}
+	log.Log.Info("pgbouncer paused", "databases", paused, "instance", p.config.Name)
+	return &v1alpha1.QuiesceResult{PgBouncer: p.result}, nil
+}
+
+func (p *PgBouncer) Unquiesce(prev *v1alpha1.PreservedConfig) error {
+	log.Log.Info("pgbouncer unquiesce in progress...")
+
+	err := p.resume()
+	if err != nil {
+		return err
+	}
+
+	db, err := p.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	targets, err := p.getTargets(db)
+	if err != nil {
+		return err
+	}
+	_, err = p.checkPaused(db, targets, false)
+	if err != nil {
+		return err
+	}
+
+	log.Log.Info("pgbouncer resumed", "instance", p.config.Name)
+	return nil
+}
+
+func (p *PgBouncer) resume() error {
+	db, err := p.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	targets, err := p.getTargets(db)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range getCmds(ResumeCmd, targets) {
+		_, err = db.Exec(cmd)
+		if err != nil && !strings.Contains(err.Error(), "not paused") {
+			log.Log.Error(err, "could not resume pgbouncer", "cmd", cmd, "instance", p.config.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// getTargets returns the databases in spec, or all databases of the pooler if
+// no database specified
+func (p *PgBouncer) getTargets(db *sql.DB) ([]string, error) {
+	if len(p.config.Databases) > 0 {
+		return p.config.Databases, nil
+	}
+
+	databases, err := p.getDatabases(db)
+	if err != nil {
+		log.Log.Error(err, "cannot show pgbouncer databases", "instance", p.config.Name)
+		return nil, err
+	}
+	return listTargets(databases)
+}
+
+// listTargets returns the pooler databases in order, global PAUSE is not
+// reported by SHOW DATABASES so each database is paused by name
+func listTargets(databases map[string]bool) ([]string, error) {
+	var targets []string
+	for dbname := range databases {
+		if !databaseNamePattern.MatchString(dbname) {
+			return nil, fmt.Errorf("invalid pgbouncer database name %s", dbname)
+		}
+		targets = append(targets, dbname)
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// getCmds returns the command for each target database
+func getCmds(cmd string, targets []string) []string {
+	var cmds []string
+	for _, dbname := range targets {
+		cmds = append(cmds, fmt.Sprintf("%s %s;", cmd, dbname))
+	}
+	return cmds
+}
+
+// checkPaused verifies the target databases are in expected paused state,
+// paused databases are returned
+func (p *PgBouncer) checkPaused(db *sql.DB, targets []string, expected bool) ([]string, error) {
+	databases, err := p.getDatabases(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var paused []string
+	for _, dbname := range targets {
+		state, ok := databases[dbname]
+		if !ok {
+			return nil, fmt.Errorf("database %s is not found in pgbouncer %s", dbname, p.config.Host)
+		}
+		if state != expected {
+			return nil, fmt.Errorf("database %s paused state in pgbouncer is %t, expected %t", dbname, state, expected)
+		}
+		if state {
+			paused = append(paused, dbname)
+		}
+	}
+	return paused, nil
+}
+
+// getDatabases returns databases of the pooler and whether they are paused,
+// admin console itself is skipped
+func (p *PgBouncer) getDatabases(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(ShowDatabasesCmd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	databases := make(map[string]bool)
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		var name, paused string
+		for i, column := range columns {
+			switch column {
+			case "name":
+				name = values[i].String
+			case "paused":
+				paused = values[i].String
+			}
+		}
+		if name == AdminDatabase {
+			continue
+		}
+		databases[name] = paused == "1"
+	}
+	return databases, rows.Err()
+}
+
+func (p *PgBouncer) open() (*sql.DB, error) {
+	host, port, err := net.SplitHostPort(p.config.Host)
+	if err != nil {
+		host = p.config.Host
+		port = DefaultPort
+	}
+
+	// admin console only supports simple query protocol
+	connstr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, p.config.Username, p.config.Password, AdminDatabase)
+	db, err := sql.Open("postgres", connstr)
+	if err != nil {
+		log.Log.Error(err, "cannot connect to pgbouncer admin console", "instance", p.config.Name)
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,53 @@
+package pgbouncer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		databases map[string]bool
+		want      []string
+		wantErr   bool
+	}{
+		{name: "all databases", databases: map[string]bool{"orders": false, "app_db": true, "users.v2": false}, want: []string{"app_db", "orders", "users.v2"}},
+		{name: "no database", databases: map[string]bool{}, want: nil},
+		{name: "invalid name", databases: map[string]bool{"app db": false}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listTargets(tt.databases)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCmds(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		targets []string
+		want    []string
+	}{
+		{name: "pause each database", cmd: PauseCmd, targets: []string{"app_db", "orders"}, want: []string{"PAUSE app_db;", "PAUSE orders;"}},
+		{name: "resume each database", cmd: ResumeCmd, targets: []string{"app_db"}, want: []string{"RESUME app_db;"}},
+		{name: "no database", cmd: PauseCmd, targets: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getCmds(tt.cmd, tt.targets)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCmds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/appconfig"
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	ArchiverQuery = "SELECT last_archived_wal, last_failed_wal FROM pg_stat_archiver;"
+
+	DefaultArchiveWaitTimeout = 3 * time.Minute
+
+	// length of wal segment file name, timeline + log + segment
+	WalFileNameLength = 24
+)
+
+// getBackupParams returns backup label, fast checkpoint and wait for archive from params
+func getBackupParams(config appconfig.Config) (string, bool, string, error) {
+	label := config.Params[v1alpha1.PgBackupLabel]
+	if label == "" {
+		label = config.Name
+		if config.JobName != "" {
+			label = fmt.Sprintf("%s-%s", config.Name, config.JobName)
+		}
+	}
+
+	fast := true
+	switch config.Params[v1alpha1.PgCheckpoint] {
+	case "", v1alpha1.PgCheckpointFast:
+	case v1alpha1.PgCheckpointSpread:
+		fast = false
+	default:
+		return "", false, "", fmt.Errorf("invalid param %s: %s", v1alpha1.PgCheckpoint, config.Params[v1alpha1.PgCheckpoint])
+	}
+
+	waitForArchive := config.Params[v1alpha1.PgWaitForArchive]
+	switch waitForArchive {
+	case "", "true", "false":
+	default:
+		return "", false, "", fmt.Errorf("invalid param %s: %s", v1alpha1.PgWaitForArchive, waitForArchive)
+	}
+
+	return label, fast, waitForArchive, nil
+}
+
+func getBackupMode(params map[string]string) (string, error) {
+	mode := params[v1alpha1.PgBackupMode]
+	switch mode {
+	case "", v1alpha1.PgBackupModeExclusive, v1alpha1.PgBackupModeNonExclusive:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid param %s: %s", v1alpha1.PgBackupMode, mode)
+}
+
+// verifyArchived confirms the pending wal segment is archived, it's checked
+// once in each unquiesce until the deadline
+func (pg *PG) verifyArchived(s *session.Session) error {
+	if pg.archiveDeadline.IsZero() {
+		pg.archiveDeadline = time.Now().Add(DefaultArchiveWaitTimeout)
+	}
+
+	archived, err := pg.checkArchived(s, pg.pendingArchive)
+	if err == nil && !archived {
+		if time.Now().Before(pg.archiveDeadline) {
+			return fmt.Errorf("%w, wal %s is not archived yet", session.ErrInProgress, pg.pendingArchive)
+		}
+		err = fmt.Errorf("wal %s is not archived in %v", pg.pendingArchive, DefaultArchiveWaitTimeout)
+	}
+	if err != nil {
+		// next unquiesce waits for another period
+		pg.archiveDeadline = time.Time{}
+		log.Log.Error(err, "postgres backup wal is not archived", "instance", pg.config.Name)
+		return err
+	}
+	pg.pendingArchive = ""
+	pg.archiveDeadline = time.Time{}
+	return nil
+}
+
+// checkArchived returns true if the archiver reaches the last wal segment of
+// the backup, wal segments are archived in order
+func (pg *PG) checkArchived(s *session.Session, stopWalFile string) (bool, error) {
+	if stopWalFile == "" {
+		return false, fmt.Errorf("stop wal file of the backup is unknown")
+	}
+
+	var lastArchived, lastFailed sql.NullString
+	err := s.QueryRow(ArchiverQuery, &lastArchived, &lastFailed)
+	if err != nil {
+		return false, err
+	}
+	// skip timeline history files
+	if len(lastArchived.String) < WalFileNameLength || lastArchived.String[:WalFileNameLength] < stopWalFile {
+		log.Log.Info("postgres backup wal is not archived yet", "wal", stopWalFile, "last archived", lastArchived.String, "last failed", lastFailed.String, "instance", pg.config.Name)
+		return false, nil
+	}
+
+	log.Log.Info("postgres backup wal archived", "wal", stopWalFile, "last archived", lastArchived.String, "instance", pg.config.Name)
+	return true, nil
+}
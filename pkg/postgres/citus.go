@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	CITUS_CREATE_RESTORE_POINT = "citus_create_restore_point"
+
+	CitusExtensionQuery = "SELECT count(*) FROM pg_extension WHERE extname = 'citus';"
+	CitusWorkersQuery   = "SELECT nodename, nodeport FROM pg_dist_node WHERE noderole = 'primary' AND isactive AND groupid <> 0 ORDER BY nodeid;"
+	CitusWorkerLSNQuery = "SELECT nodename, nodeport, success, result FROM run_command_on_workers($$SELECT pg_current_wal_lsn()$$);"
+)
+
+// isCitusCoordinator returns true if citus extension is installed and workers
+// are registered, the restore point must be created on all nodes
+func (pg *PG) isCitusCoordinator(s *session.Session) (bool, error) {
+	var count int
+	err := s.QueryRow(CitusExtensionQuery, &count)
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+
+	workers, err := s.QueryRows(CitusWorkersQuery)
+	if err != nil {
+		return false, err
+	}
+	return len(workers) > 0, nil
+}
+
+// createClusterRestorePoint creates citus restore point on citus coordinator,
+// otherwise a restore point of the server
+func (pg *PG) createClusterRestorePoint(s *session.Session) error {
+	citus, err := pg.isCitusCoordinator(s)
+	if err != nil {
+		log.Log.Error(err, "could not detect citus", "instance", pg.config.Name)
+		return err
+	}
+	if citus {
+		return pg.createCitusRestorePoint(s, pg.result)
+	}
+	return pg.createRestorePoint(s, pg.result)
+}
+
+// createCitusRestorePoint creates a restore point on coordinator and all
+// workers with distributed commits blocked, so the shards line up
+func (pg *PG) createCitusRestorePoint(s *session.Session, result *v1alpha1.PgResult) error {
+	name := pg.getRestorePointName(time.Now())
+
+	var lsn string
+	err := s.QueryRow(fmt.Sprintf("SELECT %s(%s);", CITUS_CREATE_RESTORE_POINT, pq.QuoteLiteral(name)), &lsn)
+	if err != nil {
+		return err
+	}
+	result.RestorePoint = name
+	result.RestorePointLSN = lsn
+	log.Log.Info("citus restore point created", "name", name, "lsn", lsn, "instance", pg.config.Name)
+
+	result.CitusWorkers, err = pg.getCitusWorkers(s)
+	return err
+}
+
+// getCitusWorkers lists primary workers and their current wal location
+func (pg *PG) getCitusWorkers(s *session.Session) ([]v1alpha1.PgCitusWorker, error) {
+	rows, err := s.QueryRows(CitusWorkersQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	lsnRows, err := s.QueryRows(CitusWorkerLSNQuery)
+	if err != nil {
+		return nil, err
+	}
+	lsns := make(map[string]string)
+	for _, row := range lsnRows {
+		if row["success"] != "true" {
+			log.Log.Info("warning: failed to get citus worker lsn", "worker", row["nodename"], "port", row["nodeport"], "error", row["result"])
+			continue
+		}
+		lsns[net.JoinHostPort(row["nodename"], row["nodeport"])] = row["result"]
+	}
+
+	var workers []v1alpha1.PgCitusWorker
+	for _, row := range rows {
+		port, err := strconv.ParseInt(row["nodeport"], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid citus worker port %s, err: %v", row["nodeport"], err)
+		}
+		workers = append(workers, v1alpha1.PgCitusWorker{
+			Host: row["nodename"],
+			Port: int32(port),
+			LSN:  lsns[net.JoinHostPort(row["nodename"], row["nodeport"])],
+		})
+	}
+	return workers, nil
+}
@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+)
+
+const (
+	FlavorPostgres  = "postgres"
+	FlavorOpenGauss = "opengauss"
+	FlavorKingbase  = "kingbase"
+	FlavorPolarDB   = "polardb"
+
+	VersionQuery = "SELECT version(), current_setting('server_version'), current_setting('server_version_num');"
+
+	// versions in server_version_num format
+	NonExclusiveMinVersion     = 90600
+	ControlFunctionMinVersion  = 90600
+	WalFunctionMinVersion      = 100000
+	ReplayPauseStateMinVersion = 140000
+	BackupStartMinVersion      = 150000
+)
+
+// KingbaseES renames system functions from pg_ to sys_
+var flavorFunctionPrefix = map[string]string{
+	FlavorKingbase: "sys_",
+}
+
+// detectServer gets version and flavor of the server
+func (pg *PG) detectServer(db *sql.DB) error {
+	var description, version, versionNum string
+	err := db.QueryRow(VersionQuery).Scan(&description, &version, &versionNum)
+	if err != nil {
+		log.Log.Error(err, "could not get postgres version")
+		return err
+	}
+
+	pg.versionNum, err = strconv.Atoi(versionNum)
+	if err != nil {
+		log.Log.Error(err, "invalid postgres server_version_num", "version", versionNum)
+		return err
+	}
+	pg.version = version
+
+	lower := strings.ToLower(description)
+	switch {
+	case strings.Contains(lower, "opengauss"):
+		pg.flavor = FlavorOpenGauss
+	case strings.Contains(lower, "kingbase"):
+		pg.flavor = FlavorKingbase
+	case strings.Contains(lower, "polardb"):
+		pg.flavor = FlavorPolarDB
+	default:
+		pg.flavor = FlavorPostgres
+	}
+
+	log.Log.Info("get postgres version", "version", version, "version num", pg.versionNum, "flavor", pg.flavor, "instance", pg.config.Name)
+	return nil
+}
+
+func (pg *PG) versionAtLeast(versionNum int) bool {
+	return pg.versionNum >= versionNum
+}
+
+// fn returns name of the system function in the server flavor
+func (pg *PG) fn(name string) string {
+	prefix, ok := flavorFunctionPrefix[pg.flavor]
+	if !ok {
+		return name
+	}
+	return prefix + strings.TrimPrefix(name, "pg_")
+}
+
+// isNonExclusive returns true if the backup is bound to its session, exclusive
+// backup is kept by default before v15 where it's removed, backup on standby
+// is always non-exclusive, openGauss only supports exclusive backup
+func (pg *PG) isNonExclusive() bool {
+	switch pg.backupMode {
+	case v1alpha1.PgBackupModeExclusive:
+		return false
+	case v1alpha1.PgBackupModeNonExclusive:
+		return true
+	}
+	if pg.flavor == FlavorOpenGauss {
+		return false
+	}
+	if pg.standby {
+		return pg.versionAtLeast(NonExclusiveMinVersion)
+	}
+	return pg.versionAtLeast(BackupStartMinVersion)
+}
+
+// checkBackupMode refuses the backup mode not supported by the server
+func (pg *PG) checkBackupMode() error {
+	switch pg.backupMode {
+	case v1alpha1.PgBackupModeExclusive:
+		if pg.versionAtLeast(BackupStartMinVersion) {
+			return fmt.Errorf("exclusive backup is removed since v15, current version: %s", pg.version)
+		}
+	case v1alpha1.PgBackupModeNonExclusive:
+		if pg.flavor == FlavorOpenGauss || !pg.versionAtLeast(NonExclusiveMinVersion) {
+			return fmt.Errorf("non-exclusive backup is not supported, current version: %s, flavor: %s", pg.version, pg.flavor)
+		}
+	}
+	return nil
+}
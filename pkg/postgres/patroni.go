@@ -0,0 +1,235 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/pkg/appconfig"
+)
+
+const (
+	// pause state before quiesce, quiesce pauses patroni only if it's false,
+	// so unquiesce resumes patroni only then
+	PatroniPaused = "patroni_paused"
+	// member selected to quiesce, unquiesce is done on it after restart
+	PatroniMember = "patroni_member"
+
+	PatroniClusterPath = "/cluster"
+	PatroniConfigPath  = "/config"
+
+	// leader role was named master before patroni v3.0
+	PatroniLeader        = "leader"
+	PatroniMaster        = "master"
+	PatroniStandbyLeader = "standby_leader"
+	PatroniReplica       = "replica"
+	PatroniSyncStandby   = "sync_standby"
+	// replica state was running before patroni v3.0
+	PatroniRunning   = "running"
+	PatroniStreaming = "streaming"
+)
+
+type patroniMember struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	State string `json:"state"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+}
+
+type patroniCluster struct {
+	Members []patroniMember `json:"members"`
+	Pause   bool            `json:"pause"`
+}
+
+func (m *patroniMember) isLeader() bool {
+	return m.Role == PatroniLeader || m.Role == PatroniMaster || m.Role == PatroniStandbyLeader
+}
+
+func (m *patroniMember) isReplica() bool {
+	return (m.Role == PatroniReplica || m.Role == PatroniSyncStandby) &&
+		(m.State == PatroniRunning || m.State == PatroniStreaming)
+}
+
+func (m *patroniMember) endpoint() string {
+	return net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+}
+
+func (pg *PG) patroniURL(path string) string {
+	return strings.TrimSuffix(pg.patroniEndpoint, "/") + path
+}
+
+func (pg *PG) getPatroniCluster() (*patroniCluster, error) {
+	client := &http.Client{Timeout: appconfig.ConnectionTimeout}
+	resp, err := client.Get(pg.patroniURL(PatroniClusterPath))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to get patroni cluster, status: %s, body: %s", resp.Status, string(body))
+	}
+
+	cluster := &patroniCluster{}
+	err = json.NewDecoder(resp.Body).Decode(cluster)
+	if err != nil {
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// selectPatroniMember returns the leader, or a running replica in standby mode
+func (pg *PG) selectPatroniMember(cluster *patroniCluster) (*patroniMember, error) {
+	for i := range cluster.Members {
+		member := &cluster.Members[i]
+		if pg.standby && member.isReplica() {
+			return member, nil
+		}
+		if !pg.standby && member.isLeader() {
+			return member, nil
+		}
+	}
+
+	if pg.standby {
+		return nil, fmt.Errorf("no running replica found in patroni cluster %s", pg.patroniEndpoint)
+	}
+	return nil, fmt.Errorf("no leader found in patroni cluster %s", pg.patroniEndpoint)
+}
+
+// resolvePatroniTarget selects the member to quiesce from patroni cluster
+func (pg *PG) resolvePatroniTarget() error {
+	cluster, err := pg.getPatroniCluster()
+	if err != nil {
+		log.Log.Error(err, "could not get patroni cluster", "instance", pg.config.Name)
+		return err
+	}
+
+	member, err := pg.selectPatroniMember(cluster)
+	if err != nil {
+		return err
+	}
+
+	pg.targetHost = member.Host
+	pg.targetPort = strconv.Itoa(member.Port)
+	log.Log.Info("select patroni member to quiesce", "member", member.Name, "role", member.Role, "endpoint", member.endpoint(), "instance", pg.config.Name)
+	return nil
+}
+
+// restorePatroniTarget takes the member selected by quiesce, member selected
+// again may be changed after the manager restarted
+func (pg *PG) restorePatroniTarget(params map[string]string) error {
+	member := params[PatroniMember]
+	if member == "" {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(member)
+	if err != nil {
+		return fmt.Errorf("invalid patroni member %s: %v", member, err)
+	}
+	if host != pg.targetHost || port != pg.targetPort {
+		log.Log.Info("unquiesce on patroni member selected by quiesce", "member", member, "instance", pg.config.Name)
+	}
+	pg.targetHost = host
+	pg.targetPort = port
+	return nil
+}
+
+// isPatroniPaused returns if patroni cluster is in maintenance mode
+func (pg *PG) isPatroniPaused() (bool, error) {
+	cluster, err := pg.getPatroniCluster()
+	if err != nil {
+		return false, err
+	}
+	return cluster.Pause, nil
+}
+
+// setPatroniPause turns on or off patroni maintenance mode, automatic
+// failover is disabled in maintenance mode
+func (pg *PG) setPatroniPause(pause bool) error {
+	body, err := json.Marshal(map[string]bool{"pause": pause})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, pg.patroniURL(PatroniConfigPath), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: appconfig.ConnectionTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to set patroni pause %t, status: %s, body: %s", pause, resp.Status, string(respBody))
+	}
+
+	log.Log.Info("patroni pause is set", "pause", pause, "instance", pg.config.Name)
+	return nil
+}
+
+// pausePatroni pauses patroni and confirms the target is still the selected
+// member, failover may happen before the pause
+func (pg *PG) pausePatroni() error {
+	cluster, err := pg.getPatroniCluster()
+	if err != nil {
+		return err
+	}
+	// pause state saved by prepare tells unquiesce whether to resume
+	if cluster.Pause != pg.patroniPausedBefore {
+		return fmt.Errorf("patroni pause is changed to %t after prepare", cluster.Pause)
+	}
+	if !cluster.Pause {
+		err = pg.setPatroniPause(true)
+		if err != nil {
+			return err
+		}
+		// resumed by abort if quiesce fails
+		pg.patroniPaused = true
+
+		cluster, err = pg.getPatroniCluster()
+		if err != nil {
+			return err
+		}
+	}
+
+	member, err := pg.selectPatroniMember(cluster)
+	if err != nil {
+		return err
+	}
+	if member.Host != pg.targetHost || strconv.Itoa(member.Port) != pg.targetPort {
+		return fmt.Errorf("patroni member changed to %s before pause", member.endpoint())
+	}
+	return nil
+}
+
+// resumePatroni turns off maintenance mode only if it was turned on by quiesce,
+// which is unknown if the preserved param is missing
+func (pg *PG) resumePatroni(params map[string]string) error {
+	if params[PatroniPaused] != "false" {
+		log.Log.Info("patroni was not paused by quiesce, skip resuming", "instance", pg.config.Name)
+		return nil
+	}
+
+	err := pg.setPatroniPause(false)
+	if err != nil {
+		return err
+	}
+	pg.patroniPaused = false
+	return nil
+}
@@ -2,15 +2,18 @@ package postgres
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"strconv"
+	"net"
 	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/jibudata/amberapp/api/v1alpha1"
 	"github.com/jibudata/amberapp/pkg/appconfig"
+	"github.com/jibudata/amberapp/pkg/session"
 )
 
 const (
@@ -20,16 +23,112 @@ const (
 	// >= v15.0
 	PG_BACKUP_START = "pg_backup_start"
 	PG_BACKUP_STOP  = "pg_backup_stop"
+
+	PG_BACKEND_PID = "pg_backend_pid"
+
+	DatabasesQuery = "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname;"
+
+	// backup is taken on any connectable database, this one is tried if
+	// no database in spec is connectable
+	DefaultDatabase = "postgres"
+
+	// label files returned by stopping non-exclusive backup
+	BackupLabelFile   = "backup_label"
+	TablespaceMapFile = "tablespace_map"
+
+	// backup start waits for the checkpoint, which takes about
+	// checkpoint_completion_target * checkpoint_timeout if spread, and backup
+	// stop waits for the wal segments archived, both may take much longer
+	// than a single query, so they run in background and are polled
+	BackupCmdTimeout = 1 * time.Hour
+	// time to wait for the backup start or stop in each quiesce or unquiesce
+	BackupWaitTimeout = 5 * time.Second
 )
 
 type PG struct {
-	config  appconfig.Config
-	db      *sql.DB
-	version string
+	config     appconfig.Config
+	db         *sql.DB
+	version    string
+	versionNum int
+	flavor     string
+	// connectable database to run the backup, backup applies to whole cluster
+	database string
+
+	backupLabel    string
+	fastCheckpoint bool
+	// wait for wal archived when backup stops, server default if empty
+	waitForArchive string
+	// exclusive or non-exclusive, chosen by server version if empty
+	backupMode string
+	// exclusive backup started by quiesce, it's kept by server without session
+	exclusiveStarted bool
+	// backup start or stop is running in background on the session
+	backupStarting bool
+	backupStopping bool
+	// refuse or warn unrecoverable settings, warnings are reported in result
+	configCheck string
+	warnings    []string
+	// take backup on standby and pause replay during quiesce
+	standby     bool
+	replayPause bool
+	// patroni rest api to select the member and pause failover
+	patroniEndpoint     string
+	patroniPaused       bool
+	patroniPausedBefore bool
+	targetHost          string
+	targetPort          string
+	// member is selected on connect, the error is returned by prepare so
+	// unquiesce can go on with the member saved by quiesce
+	targetErr error
+	// last wal segment of the stopped backup waiting to be archived, it's
+	// failed if not archived before the deadline
+	pendingArchive  string
+	archiveDeadline time.Time
+	// restore point is created after the backup stops, retried until created
+	pendingRestorePoint bool
+	// non-exclusive backup is aborted by server once its session is closed,
+	// the session is kept open until unquiesce
+	session *session.Session
+	// transaction of the exported snapshot, kept open until unquiesce
+	exportSnapshot  bool
+	snapshotSession *session.Session
+	// label files of the stopped backup, kept until next quiesce
+	labelFile  string
+	spcmapFile string
+	// backup aborted by server or stopped without label files, kept until
+	// next quiesce
+	lost   error
+	result *v1alpha1.PgResult
 }
 
 func (pg *PG) Init(appConfig appconfig.Config) error {
+	if pg.IsSessionHeld() {
+		err := fmt.Errorf("postgres %s is quiesced, cannot init with new config", pg.config.Name)
+		log.Log.Error(err, "")
+		return err
+	}
 	pg.config = appConfig
+
+	var err error
+	pg.backupLabel, pg.fastCheckpoint, pg.waitForArchive, err = getBackupParams(pg.config)
+	if err != nil {
+		log.Log.Error(err, "", "instance", pg.config.Name)
+		return err
+	}
+	pg.configCheck, err = getConfigCheck(pg.config.Params)
+	if err != nil {
+		log.Log.Error(err, "", "instance", pg.config.Name)
+		return err
+	}
+	pg.backupMode, err = getBackupMode(pg.config.Params)
+	if err != nil {
+		log.Log.Error(err, "", "instance", pg.config.Name)
+		return err
+	}
+	pg.standby = pg.config.Params[v1alpha1.PgStandby] == "true"
+	pg.replayPause = pg.standby && pg.config.Params[v1alpha1.PgReplayPause] == "true"
+	pg.patroniEndpoint = pg.config.Params[v1alpha1.PgPatroniEndpoint]
+	pg.exportSnapshot = pg.config.Params[v1alpha1.PgExportSnapshot] == "true"
 	return nil
 }
 
@@ -37,13 +136,14 @@ func (pg *PG) Connect() error {
 	var err error
 	log.Log.Info("postgres connecting")
 
-	connectionConfigStrings := pg.getConnectionString()
-	if len(connectionConfigStrings) == 0 {
-		return fmt.Errorf("no database found in %s", pg.config.Name)
+	// keep the member holding the backup session
+	if pg.patroniEndpoint != "" && pg.session == nil {
+		pg.targetErr = pg.resolvePatroniTarget()
 	}
 
-	for i := 0; i < len(connectionConfigStrings); i++ {
-		pg.db, err = sql.Open("postgres", connectionConfigStrings[i])
+	pg.database = ""
+	for _, dbname := range pg.getCandidateDatabases() {
+		pg.db, err = sql.Open("postgres", pg.getConnectionString(dbname))
 		if err != nil {
 			log.Log.Error(err, "cannot connect to postgres")
 			return err
@@ -51,22 +151,75 @@ func (pg *PG) Connect() error {
 
 		err = pg.db.Ping()
 		if err != nil {
-			log.Log.Error(err, fmt.Sprintf("cannot connect to postgres database %s", pg.config.Databases[i]))
-			return err
+			log.Log.Error(err, fmt.Sprintf("cannot connect to postgres database %s", dbname))
+			pg.db.Close()
+			continue
 		}
 
-		err = pg.getVersion()
+		err = pg.detectServer(pg.db)
+		pg.db.Close()
 		if err != nil {
 			return err
 		}
 
-		pg.db.Close()
+		pg.database = dbname
+		break
 	}
-	log.Log.Info("connected to postgres")
+	if pg.database == "" {
+		return fmt.Errorf("no connectable database found in %s, err: %v", pg.config.Name, err)
+	}
+
+	log.Log.Info("connected to postgres", "database", pg.database)
 	return nil
 }
 
 func (pg *PG) Prepare() (*v1alpha1.PreservedConfig, error) {
+	preserved := make(map[string]string)
+
+	err := pg.checkBackupMode()
+	if err != nil {
+		log.Log.Error(err, "", "instance", pg.config.Name)
+		return nil, err
+	}
+
+	err = pg.prepareSettings()
+	if err != nil {
+		log.Log.Error(err, "postgres settings check failed", "instance", pg.config.Name)
+		return nil, err
+	}
+
+	if pg.standby {
+		params, err := pg.prepareStandby()
+		if err != nil {
+			log.Log.Error(err, "cannot take backup from standby", "instance", pg.config.Name)
+			return nil, err
+		}
+		for key, value := range params {
+			preserved[key] = value
+		}
+	}
+
+	if pg.patroniEndpoint != "" {
+		if pg.targetErr != nil {
+			return nil, pg.targetErr
+		}
+		preserved[PatroniMember] = net.JoinHostPort(pg.targetHost, pg.targetPort)
+
+		paused, err := pg.isPatroniPaused()
+		if err != nil {
+			log.Log.Error(err, "could not get patroni pause state", "instance", pg.config.Name)
+			return nil, err
+		}
+		pg.patroniPausedBefore = paused
+		preserved[PatroniPaused] = fmt.Sprintf("%t", paused)
+	}
+
+	if len(preserved) > 0 {
+		log.Log.Info("postgres prepared", "params", preserved)
+		return &v1alpha1.PreservedConfig{
+			Params: preserved,
+		}, nil
+	}
 	return nil, nil
 }
 
@@ -74,149 +227,473 @@ func (pg *PG) Quiesce() (*v1alpha1.QuiesceResult, error) {
 	var err error
 	log.Log.Info("postgres quiesce in progress...")
 
-	backupName := "test"
-	fastStartString := "true"
-
-	connectionConfigStrings := pg.getConnectionString()
-	if len(connectionConfigStrings) == 0 {
-		return nil, fmt.Errorf("no database found in %s", pg.config.Name)
+	if pg.backupStarting {
+		return pg.finishQuiesce()
+	}
+	if pg.IsSessionHeld() {
+		err = pg.CheckSession()
+		if err == nil {
+			log.Log.Info("postgres backup already in progress", "instance", pg.config.Name)
+			return &v1alpha1.QuiesceResult{Pg: pg.result}, nil
+		}
+		log.Log.Error(err, "drop lost postgres backup session", "instance", pg.config.Name)
+		pg.closeSnapshotSession()
+		pg.closeSession()
+	}
+	pg.labelFile = ""
+	pg.spcmapFile = ""
+	pg.pendingArchive = ""
+	pg.archiveDeadline = time.Time{}
+	pg.pendingRestorePoint = false
+	pg.lost = nil
+	pg.result = &v1alpha1.PgResult{Database: pg.database, IsStandby: pg.standby, Warnings: pg.warnings}
+
+	if pg.database == "" {
+		return nil, fmt.Errorf("no connectable database found in %s", pg.config.Name)
 	}
 
-	for i := 0; i < len(connectionConfigStrings); i++ {
-		pg.db, err = sql.Open("postgres", connectionConfigStrings[i])
+	// failover during the backup leaves snapshots of mixed roles
+	if pg.patroniEndpoint != "" {
+		err = pg.pausePatroni()
 		if err != nil {
-			log.Log.Error(err, "cannot connect to postgres")
+			log.Log.Error(err, "could not pause patroni", "instance", pg.config.Name)
+			pg.abortQuiesce()
 			return nil, err
 		}
+	}
 
-		queryStr := fmt.Sprintf("select %s('%s', %s);", pg.getQuiesceCmd(), backupName, fastStartString)
+	pg.session, err = session.New("postgres", pg.getConnectionString(pg.database), pg.getBackendPIDQuery())
+	if err != nil {
+		log.Log.Error(err, "cannot connect to postgres")
+		pg.abortQuiesce()
+		return nil, err
+	}
 
-		result, queryErr := pg.db.Query(queryStr)
+	err = pg.session.Start(pg.getQuiesceCmd(pg.backupLabel, pg.fastCheckpoint), BackupCmdTimeout)
+	if err != nil {
+		log.Log.Error(err, "could not start postgres backup")
+		pg.abortQuiesce()
+		return nil, err
+	}
+	pg.backupStarting = true
 
-		if queryErr != nil {
-			if strings.Contains(queryErr.Error(), "backup is already in progress") {
-				pg.db.Close()
-				continue
-			}
+	return pg.finishQuiesce()
+}
+
+// finishQuiesce waits for the backup started in background, then pauses
+// replay and exports the snapshot
+func (pg *PG) finishQuiesce() (*v1alpha1.QuiesceResult, error) {
+	var err error
+	row, queryErr := pg.session.Wait(BackupWaitTimeout)
+	if errors.Is(queryErr, session.ErrInProgress) {
+		log.Log.Info("waiting for postgres backup started", "instance", pg.config.Name, "session", pg.session.ID())
+		return &v1alpha1.QuiesceResult{Pg: pg.result}, queryErr
+	}
+	pg.backupStarting = false
+
+	if queryErr != nil {
+		// exclusive backup started by others also keeps the cluster in backup mode
+		if !pg.isNonExclusive() && strings.Contains(queryErr.Error(), "backup is already in progress") {
+			log.Log.Info("postgres exclusive backup is already in progress", "instance", pg.config.Name)
+		} else {
 			log.Log.Error(queryErr, "could not start postgres backup")
+			pg.abortQuiesce()
 			return nil, queryErr
 		}
+	} else {
+		snapshotLocation := row["lsn"]
+		log.Log.Info(fmt.Sprintf("Successfully reach consistent recovery state at %s", snapshotLocation), "session", pg.session.ID())
+		pg.exclusiveStarted = !pg.isNonExclusive()
+		pg.result.StartLSN = snapshotLocation
+		err = pg.getStartWal(pg.session, pg.result)
+		if err != nil {
+			log.Log.Error(err, "failed to get postgres wal status", "instance", pg.config.Name)
+		}
+	}
 
-		var snapshotLocation string
-		result.Next()
+	pg.result.Databases, err = pg.getDatabases(pg.session)
+	if err != nil {
+		log.Log.Error(err, "failed to list postgres databases", "instance", pg.config.Name)
+	}
 
-		scanErr := result.Scan(&snapshotLocation)
-		if scanErr != nil {
-			log.Log.Error(scanErr, "Postgres backup apparently started but could not understand server response")
-			return nil, scanErr
+	if pg.standby {
+		if pg.replayPause {
+			err = pg.pauseReplay(pg.session)
+			if err != nil {
+				log.Log.Error(err, "could not pause postgres wal replay", "instance", pg.config.Name)
+				pg.abortQuiesce()
+				return &v1alpha1.QuiesceResult{Pg: pg.result}, err
+			}
+		}
+		err = pg.getReplayLSN(pg.session, pg.result)
+		if err != nil {
+			log.Log.Error(err, "failed to get postgres replay lsn", "instance", pg.config.Name)
 		}
-		log.Log.Info(fmt.Sprintf("Successfully reach consistent recovery state at %s", snapshotLocation))
-		pg.db.Close()
 	}
-	return nil, nil
+
+	if pg.exportSnapshot {
+		pg.result.SnapshotID, err = pg.createExportedSnapshot()
+		if err != nil {
+			log.Log.Error(err, "could not export postgres snapshot", "instance", pg.config.Name)
+			pg.abortQuiesce()
+			return &v1alpha1.QuiesceResult{Pg: pg.result}, err
+		}
+	}
+
+	if !pg.isNonExclusive() {
+		// exclusive backup is kept by server, it's stopped from a new session
+		pg.closeSession()
+		return &v1alpha1.QuiesceResult{Pg: pg.result}, nil
+	}
+
+	pg.session.Keepalive()
+	return &v1alpha1.QuiesceResult{Pg: pg.result}, nil
 }
 
 func (pg *PG) Unquiesce(prev *v1alpha1.PreservedConfig) error {
-	var err error
 	log.Log.Info("postgres unquiesce in progress...")
-	connectionConfigStrings := pg.getConnectionString()
-	if len(connectionConfigStrings) == 0 {
-		return fmt.Errorf("no database found in %s", pg.config.Name)
+
+	if pg.patroniEndpoint != "" && prev != nil {
+		err := pg.restorePatroniTarget(prev.Params)
+		if err != nil {
+			log.Log.Error(err, "", "instance", pg.config.Name)
+			return err
+		}
+	}
+
+	pg.releaseSnapshot()
+	stopErr := pg.stopBackup(prev)
+	if errors.Is(stopErr, session.ErrInProgress) {
+		// patroni is resumed after the backup is finished
+		return stopErr
+	}
+
+	// patroni pause is kept even if the backup is lost
+	if pg.patroniEndpoint != "" {
+		var params map[string]string
+		if prev != nil {
+			params = prev.Params
+		}
+		err := pg.resumePatroni(params)
+		if err != nil {
+			log.Log.Error(err, "could not resume patroni", "instance", pg.config.Name)
+			return err
+		}
+	}
+
+	return stopErr
+}
+
+func (pg *PG) stopBackup(prev *v1alpha1.PreservedConfig) error {
+	// replay pause is kept by server even if the session is lost
+	if pg.replayPause {
+		err := pg.resumeReplay(prev)
+		if err != nil {
+			log.Log.Error(err, "could not resume postgres wal replay", "instance", pg.config.Name)
+			return err
+		}
+	}
+
+	if pg.backupStarting {
+		// unquiesce is requested before the backup started, failed start is
+		// aborted and there is nothing to stop
+		_, err := pg.finishQuiesce()
+		if errors.Is(err, session.ErrInProgress) {
+			return err
+		}
+	}
+	if pg.backupStopping {
+		return pg.waitBackupStop()
 	}
 
-	for i := 0; i < len(connectionConfigStrings); i++ {
-		pg.db, err = sql.Open("postgres", connectionConfigStrings[i])
+	var err error
+	if pg.session == nil {
+		if pg.lost != nil {
+			// snapshot cannot be restored without backup label, the error
+			// wraps session.ErrLost so unquiesce is finished with it
+			return pg.lost
+		}
+		if pg.pendingRestorePoint || pg.pendingArchive != "" {
+			// backup is stopped, restore point or wal archiving is not done yet
+			return pg.finishPendingBackup()
+		}
+		if pg.isNonExclusive() {
+			log.Log.Info("no postgres backup session held, skip stopping backup", "instance", pg.config.Name)
+			return nil
+		}
+		if pg.database == "" {
+			return fmt.Errorf("no connectable database found in %s", pg.config.Name)
+		}
+		// exclusive backup is kept by server, stop it from a new session
+		pg.session, err = session.New("postgres", pg.getConnectionString(pg.database), pg.getBackendPIDQuery())
 		if err != nil {
 			log.Log.Error(err, "cannot connect to postgres")
 			return err
 		}
-		defer pg.db.Close()
+	} else {
+		// the backup was aborted by server together with the lost session
+		err = pg.session.Err()
+		if err != nil {
+			log.Log.Error(err, "postgres backup session is lost", "instance", pg.config.Name)
+			pg.lost = fmt.Errorf("%w, postgres backup of %s is aborted: %v", session.ErrLost, pg.config.Name, err)
+			pg.closeSession()
+			return pg.lost
+		}
+	}
+
+	err = pg.session.Start(pg.getUnQuiesceCmd(), BackupCmdTimeout)
+	if err != nil {
+		log.Log.Error(err, "could not stop backup")
+		return err
+	}
+	pg.backupStopping = true
 
-		result, queryErr := pg.db.Query(fmt.Sprintf("select %s();", pg.getUnQuiesceCmd()))
-		if queryErr != nil {
-			if strings.Contains(queryErr.Error(), "not in progress") {
-				pg.db.Close()
-				continue
+	return pg.waitBackupStop()
+}
+
+// waitBackupStop waits for the backup stopped in background, then finishes
+// the stopped backup
+func (pg *PG) waitBackupStop() error {
+	row, err := pg.session.Wait(BackupWaitTimeout)
+	if errors.Is(err, session.ErrInProgress) {
+		log.Log.Info("waiting for postgres backup stopped", "instance", pg.config.Name, "session", pg.session.ID())
+		return err
+	}
+	pg.backupStopping = false
+
+	if err != nil {
+		log.Log.Error(err, "could not stop backup")
+		if !pg.isNonExclusive() {
+			// exclusive backup is stopped from a new session on retry
+			pg.closeSession()
+			if strings.Contains(err.Error(), "not in progress") {
+				pg.exclusiveStarted = false
+				return nil
 			}
-			log.Log.Error(queryErr, "could not stop backup")
-			return queryErr
+			return err
+		}
+		if strings.Contains(err.Error(), "not in progress") {
+			// stopped by a cancelled attempt, label files are gone with it
+			pg.lost = fmt.Errorf("%w, postgres backup of %s is stopped without backup label: %v", session.ErrLost, pg.config.Name, err)
+			pg.closeSession()
+			return pg.lost
+		}
+		// keep the session so the backup is not aborted, stop is retried
+		return err
+	}
+	pg.exclusiveStarted = false
+	pg.labelFile = row["labelfile"]
+	pg.spcmapFile = row["spcmapfile"]
+	defer pg.closeSession()
+
+	return pg.backupStopped(pg.session, row["lsn"])
+}
+
+// backupStopped records the stop position, then creates the restore point and
+// confirms the wal archived
+func (pg *PG) backupStopped(s *session.Session, snapshotLocation string) error {
+	log.Log.Info("postgres backup stopped", "lsn", snapshotLocation, "session", s.ID())
+
+	// result is unknown if the manager is restarted after quiesce
+	if pg.result != nil {
+		pg.result.StopLSN = snapshotLocation
+		err := pg.getStopWal(s, pg.result)
+		if err != nil {
+			log.Log.Error(err, "failed to get postgres wal status", "instance", pg.config.Name)
+		}
+		if pg.waitForArchive == "true" {
+			pg.pendingArchive = pg.result.StopWalFile
 		}
+	}
 
-		var snapshotLocation string
-		result.Next()
+	// restore point cannot be created during recovery
+	pg.pendingRestorePoint = !pg.standby
+	return pg.finishBackup(s)
+}
 
-		scanErr := result.Scan(&snapshotLocation)
-		if scanErr != nil {
-			log.Log.Error(scanErr, "Postgres backup apparently stopped but could not understand server response")
-			return scanErr
+// finishBackup creates the restore point and confirms the wal archived after
+// the backup is stopped
+func (pg *PG) finishBackup(s *session.Session) error {
+	if pg.pendingRestorePoint {
+		err := pg.createClusterRestorePoint(s)
+		if err != nil {
+			log.Log.Error(err, "failed to create postgres restore point", "instance", pg.config.Name)
+			return err
 		}
+		pg.pendingRestorePoint = false
+	}
+
+	if pg.pendingArchive != "" {
+		return pg.verifyArchived(s)
 	}
 	return nil
 }
 
-func (pg *PG) getConnectionString() []string {
-	var dbname string
-	var connstr []string
+// finishPendingBackup retries finishing the stopped backup from a new session
+func (pg *PG) finishPendingBackup() error {
+	s, err := session.New("postgres", pg.getConnectionString(pg.database), pg.getBackendPIDQuery())
+	if err != nil {
+		log.Log.Error(err, "cannot connect to postgres")
+		return err
+	}
+	defer s.Close()
 
-	if len(pg.config.Databases) == 0 {
-		log.Log.Error(fmt.Errorf("no database found in %s", pg.config.Name), "")
-		return connstr
+	return pg.finishBackup(s)
+}
+
+// GetResult returns quiesce result updated by stopping the backup
+func (pg *PG) GetResult() *v1alpha1.QuiesceResult {
+	if pg.result == nil {
+		return nil
+	}
+	return &v1alpha1.QuiesceResult{Pg: pg.result.DeepCopy()}
+}
+
+// GetBackupLabel returns backup_label and tablespace_map of the stopped
+// non-exclusive backup, exclusive backup writes them in data directory
+func (pg *PG) GetBackupLabel() map[string]string {
+	if pg.labelFile == "" {
+		return nil
 	}
 
-	for i := 0; i < len(pg.config.Databases); i++ {
-		dbname = pg.config.Databases[i]
-		connstr = append(connstr, fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable", pg.config.Host, pg.config.Username, pg.config.Password, dbname))
+	files := map[string]string{
+		BackupLabelFile: pg.labelFile,
 	}
-	return connstr
+	if pg.spcmapFile != "" {
+		files[TablespaceMapFile] = pg.spcmapFile
+	}
+	return files
 }
 
-func (pg *PG) getVersion() error {
-	var version string
-	result, queryErr := pg.db.Query("show server_version;")
-	if queryErr != nil {
-		log.Log.Error(queryErr, "could get postgres version")
-		return queryErr
+// IsSessionHeld returns true if the backup session or the exported snapshot is
+// kept open, exclusive backup holds no session
+func (pg *PG) IsSessionHeld() bool {
+	return pg.session != nil || pg.snapshotSession != nil
+}
+
+// CheckSession returns error if the backup session or the exported snapshot is lost
+func (pg *PG) CheckSession() error {
+	if pg.snapshotSession != nil {
+		err := pg.snapshotSession.Err()
+		if err != nil {
+			return fmt.Errorf("exported snapshot is lost: %w", err)
+		}
+	}
+	if pg.session == nil {
+		return nil
+	}
+	return pg.session.Err()
+}
+
+// abortQuiesce releases the backup session, stops the exclusive backup and
+// resumes patroni paused by quiesce
+func (pg *PG) abortQuiesce() {
+	pg.closeSnapshotSession()
+	pg.closeSession()
+
+	if pg.exclusiveStarted {
+		err := pg.abortExclusiveBackup()
+		if err != nil {
+			log.Log.Error(err, "failed to stop postgres exclusive backup", "instance", pg.config.Name)
+		}
+	}
+
+	if pg.patroniPaused {
+		err := pg.setPatroniPause(false)
+		if err != nil {
+			log.Log.Error(err, "failed to resume patroni", "instance", pg.config.Name)
+			return
+		}
+		pg.patroniPaused = false
 	}
+}
 
-	result.Next()
-	scanErr := result.Scan(&version)
-	if scanErr != nil {
-		log.Log.Error(scanErr, "scan postgres version with error")
-		return scanErr
+func (pg *PG) closeSession() {
+	if pg.session != nil {
+		// backup start or stop running in background is cancelled
+		pg.session.Close()
+		pg.session = nil
 	}
+	pg.backupStarting = false
+	pg.backupStopping = false
+}
 
-	pg.version = strings.Split(version, " ")[0]
-	log.Log.Info("get postgres version", "version", version, "instance", pg.config.Name)
+// abortExclusiveBackup stops the exclusive backup started by the failed quiesce
+func (pg *PG) abortExclusiveBackup() error {
+	s, err := session.New("postgres", pg.getConnectionString(pg.database), pg.getBackendPIDQuery())
+	if err != nil {
+		return err
+	}
+	defer s.Close()
 
+	var snapshotLocation string
+	err = s.QueryRow(pg.getUnQuiesceCmd(), &snapshotLocation)
+	if err != nil && !strings.Contains(err.Error(), "not in progress") {
+		return err
+	}
+	pg.exclusiveStarted = false
 	return nil
 }
 
-func (pg *PG) isVersionAboveV15() bool {
-	aboveV15 := false
-	if pg.version != "" {
-		version, err := strconv.ParseFloat(pg.version, 64)
-		if err != nil {
-			log.Log.Error(err, "failed to convert version to number", "version", pg.version)
-		} else {
-			if version >= 15.0 {
-				aboveV15 = true
-			}
+// getCandidateDatabases returns databases in spec and the default database
+func (pg *PG) getCandidateDatabases() []string {
+	candidates := append([]string{}, pg.config.Databases...)
+	for _, dbname := range candidates {
+		if dbname == DefaultDatabase {
+			return candidates
 		}
 	}
+	return append(candidates, DefaultDatabase)
+}
+
+func (pg *PG) getConnectionString(dbname string) string {
+	if pg.targetHost != "" {
+		return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", pg.targetHost, pg.targetPort, pg.config.Username, pg.config.Password, dbname)
+	}
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s sslmode=disable", pg.config.Host, pg.config.Username, pg.config.Password, dbname)
+}
+
+// getDatabases returns databases of the cluster covered by the backup
+func (pg *PG) getDatabases(s *session.Session) ([]string, error) {
+	rows, err := s.QueryRows(DatabasesQuery)
+	if err != nil {
+		return nil, err
+	}
 
-	return aboveV15
+	var databases []string
+	for _, row := range rows {
+		databases = append(databases, row["datname"])
+	}
+	return databases, nil
+}
+
+func (pg *PG) getBackendPIDQuery() string {
+	return fmt.Sprintf("SELECT %s();", pg.fn(PG_BACKEND_PID))
 }
 
-func (pg *PG) getQuiesceCmd() string {
-	if pg.isVersionAboveV15() {
-		return PG_BACKUP_START
+func (pg *PG) getQuiesceCmd(label string, fast bool) string {
+	switch {
+	case pg.isNonExclusive() && pg.versionAtLeast(BackupStartMinVersion):
+		return fmt.Sprintf("SELECT %s(%s, %t) AS lsn;", pg.fn(PG_BACKUP_START), pq.QuoteLiteral(label), fast)
+	case pg.isNonExclusive():
+		return fmt.Sprintf("SELECT %s(%s, %t, false) AS lsn;", pg.fn(PG_START_BACKUP), pq.QuoteLiteral(label), fast)
 	}
-	return PG_START_BACKUP
+	return fmt.Sprintf("SELECT %s(%s, %t) AS lsn;", pg.fn(PG_START_BACKUP), pq.QuoteLiteral(label), fast)
 }
 
 func (pg *PG) getUnQuiesceCmd() string {
-	if pg.isVersionAboveV15() {
-		return PG_BACKUP_STOP
+	switch {
+	case pg.isNonExclusive() && pg.versionAtLeast(BackupStartMinVersion):
+		if pg.waitForArchive != "" {
+			return fmt.Sprintf("SELECT lsn, labelfile, spcmapfile FROM %s(%s);", pg.fn(PG_BACKUP_STOP), pg.waitForArchive)
+		}
+		return fmt.Sprintf("SELECT lsn, labelfile, spcmapfile FROM %s();", pg.fn(PG_BACKUP_STOP))
+	case pg.isNonExclusive():
+		// wait_for_archive is supported since v10
+		if pg.waitForArchive != "" && pg.versionAtLeast(WalFunctionMinVersion) {
+			return fmt.Sprintf("SELECT lsn, labelfile, spcmapfile FROM %s(false, %s);", pg.fn(PG_STOP_BACKUP), pg.waitForArchive)
+		}
+		return fmt.Sprintf("SELECT lsn, labelfile, spcmapfile FROM %s(false);", pg.fn(PG_STOP_BACKUP))
 	}
-	return PG_STOP_BACKUP
+	return fmt.Sprintf("SELECT %s() AS lsn;", pg.fn(PG_STOP_BACKUP))
 }
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	PG_CREATE_RESTORE_POINT = "pg_create_restore_point"
+
+	// restore point name is limited to 63 characters
+	MaxRestorePointLength  = 63
+	RestorePointTimeFormat = "20060102150405"
+)
+
+// getRestorePointName returns <job>-<hook>-<timestamp>, job and hook names are
+// truncated to keep the timestamp
+func (pg *PG) getRestorePointName(now time.Time) string {
+	timestamp := now.UTC().Format(RestorePointTimeFormat)
+
+	var parts []string
+	for _, name := range []string{pg.config.JobName, pg.config.Name} {
+		if name != "" {
+			parts = append(parts, name)
+		}
+	}
+	prefix := strings.Join(parts, "-")
+
+	maxPrefix := MaxRestorePointLength - len(timestamp) - 1
+	if len(prefix) > maxPrefix {
+		prefix = prefix[:maxPrefix]
+	}
+	if prefix == "" {
+		return timestamp
+	}
+	return prefix + "-" + timestamp
+}
+
+// createRestorePoint creates a named restore point right after the backup
+// stops, recovery cannot stop before the end of backup, the name and lsn are
+// recorded in result
+func (pg *PG) createRestorePoint(s *session.Session, result *v1alpha1.PgResult) error {
+	name := pg.getRestorePointName(time.Now())
+
+	var lsn string
+	err := s.QueryRow(fmt.Sprintf("SELECT %s(%s);", pg.fn(PG_CREATE_RESTORE_POINT), pq.QuoteLiteral(name)), &lsn)
+	if err != nil {
+		return err
+	}
+
+	result.RestorePoint = name
+	result.RestorePointLSN = lsn
+	log.Log.Info("postgres restore point created", "name", name, "lsn", lsn, "instance", pg.config.Name)
+	return nil
+}
@@ -0,0 +1,101 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+)
+
+const (
+	FullPageWrites = "full_page_writes"
+	Fsync          = "fsync"
+	ArchiveMode    = "archive_mode"
+
+	SettingsQuery = "SELECT name, setting FROM pg_settings WHERE name IN ('full_page_writes', 'fsync', 'archive_mode');"
+)
+
+func getConfigCheck(params map[string]string) (string, error) {
+	check, ok := params[v1alpha1.PgConfigCheck]
+	if !ok || check == "" {
+		return v1alpha1.PgConfigCheckRefuse, nil
+	}
+
+	switch check {
+	case v1alpha1.PgConfigCheckRefuse, v1alpha1.PgConfigCheckWarn:
+		return check, nil
+	default:
+		return "", fmt.Errorf("invalid param %s: %s", v1alpha1.PgConfigCheck, check)
+	}
+}
+
+// checkSettings returns settings which make the snapshot unrecoverable, and
+// settings which may lose wal needed by recovery
+func (pg *PG) checkSettings(db *sql.DB) ([]string, []string, error) {
+	rows, err := db.Query(SettingsQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var name, setting string
+		err = rows.Scan(&name, &setting)
+		if err != nil {
+			return nil, nil, err
+		}
+		settings[name] = setting
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var unsafe, warnings []string
+	// torn pages cannot be repaired from wal
+	if settings[FullPageWrites] != "on" {
+		unsafe = append(unsafe, fmt.Sprintf("%s is %s", FullPageWrites, settings[FullPageWrites]))
+	}
+	// data files may be corrupted without fsync
+	if settings[Fsync] != "on" {
+		unsafe = append(unsafe, fmt.Sprintf("%s is %s", Fsync, settings[Fsync]))
+	}
+	// standby archives wal only if archive_mode is always
+	switch {
+	case settings[ArchiveMode] == "off":
+		warnings = append(warnings, fmt.Sprintf("%s is off, wal needed by recovery may be missing", ArchiveMode))
+	case pg.standby && settings[ArchiveMode] != "always":
+		warnings = append(warnings, fmt.Sprintf("%s is %s, standby does not archive wal", ArchiveMode, settings[ArchiveMode]))
+	}
+
+	return unsafe, warnings, nil
+}
+
+// prepareSettings refuses unrecoverable settings or keeps them as warnings
+// depending on config check param, the warnings are reported in result
+func (pg *PG) prepareSettings() error {
+	db, err := sql.Open("postgres", pg.getConnectionString(pg.database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	unsafe, warnings, err := pg.checkSettings(db)
+	if err != nil {
+		log.Log.Error(err, "could not get postgres settings", "instance", pg.config.Name)
+		return err
+	}
+
+	if len(unsafe) > 0 && pg.configCheck == v1alpha1.PgConfigCheckRefuse {
+		return fmt.Errorf("snapshot of postgres %s is unrecoverable: %s", pg.config.Name, strings.Join(unsafe, ", "))
+	}
+
+	pg.warnings = append(unsafe, warnings...)
+	if len(pg.warnings) > 0 {
+		log.Log.Info("warning: unsafe postgres settings for snapshot", "warnings", pg.warnings, "instance", pg.config.Name)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	PG_EXPORT_SNAPSHOT = "pg_export_snapshot"
+
+	BeginRepeatableReadCmd = "BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY;"
+	RollbackCmd            = "ROLLBACK;"
+)
+
+// createExportedSnapshot opens a repeatable read transaction and exports its snapshot,
+// the transaction is kept open until unquiesce so pg_dump --snapshot can use it
+func (pg *PG) createExportedSnapshot() (string, error) {
+	var err error
+	pg.snapshotSession, err = session.New("postgres", pg.getConnectionString(pg.database), pg.getBackendPIDQuery())
+	if err != nil {
+		return "", err
+	}
+
+	err = pg.snapshotSession.Exec(BeginRepeatableReadCmd)
+	if err != nil {
+		pg.closeSnapshotSession()
+		return "", err
+	}
+
+	var snapshotID string
+	err = pg.snapshotSession.QueryRow(fmt.Sprintf("SELECT %s();", pg.fn(PG_EXPORT_SNAPSHOT)), &snapshotID)
+	if err != nil {
+		pg.closeSnapshotSession()
+		return "", err
+	}
+
+	pg.snapshotSession.Keepalive()
+	log.Log.Info("postgres snapshot exported", "snapshot", snapshotID, "session", pg.snapshotSession.ID(), "instance", pg.config.Name)
+	return snapshotID, nil
+}
+
+// releaseSnapshot ends the transaction of the exported snapshot
+func (pg *PG) releaseSnapshot() {
+	if pg.snapshotSession == nil {
+		return
+	}
+
+	if pg.snapshotSession.Err() == nil {
+		err := pg.snapshotSession.Exec(RollbackCmd)
+		if err != nil {
+			log.Log.Error(err, "failed to end postgres snapshot transaction", "instance", pg.config.Name)
+		}
+	}
+	pg.closeSnapshotSession()
+}
+
+func (pg *PG) closeSnapshotSession() {
+	if pg.snapshotSession != nil {
+		pg.snapshotSession.Close()
+		pg.snapshotSession = nil
+	}
+}
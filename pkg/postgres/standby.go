@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	WalReplayPaused = "wal_replay_paused"
+
+	PG_IS_IN_RECOVERY = "pg_is_in_recovery"
+	// >= v10.0
+	PG_WAL_REPLAY_PAUSE     = "pg_wal_replay_pause"
+	PG_WAL_REPLAY_RESUME    = "pg_wal_replay_resume"
+	PG_IS_WAL_REPLAY_PAUSED = "pg_is_wal_replay_paused"
+	PG_LAST_WAL_REPLAY_LSN  = "pg_last_wal_replay_lsn"
+	// < v10.0
+	PG_XLOG_REPLAY_PAUSE         = "pg_xlog_replay_pause"
+	PG_XLOG_REPLAY_RESUME        = "pg_xlog_replay_resume"
+	PG_IS_XLOG_REPLAY_PAUSED     = "pg_is_xlog_replay_paused"
+	PG_LAST_XLOG_REPLAY_LOCATION = "pg_last_xlog_replay_location"
+	// >= v14.0, pause is requested asynchronously
+	PG_GET_WAL_REPLAY_PAUSE_STATE = "pg_get_wal_replay_pause_state"
+	ReplayPausedState             = "paused"
+
+	DefaultReplayPauseTimeout  = 1 * time.Minute
+	ReplayPausePollingInterval = 1 * time.Second
+)
+
+func (pg *PG) getReplayFunc(wal, xlog string) string {
+	if pg.versionAtLeast(WalFunctionMinVersion) {
+		return pg.fn(wal)
+	}
+	return pg.fn(xlog)
+}
+
+func (pg *PG) isInRecovery(db *sql.DB) (bool, error) {
+	var inRecovery bool
+	err := db.QueryRow(fmt.Sprintf("SELECT %s();", pg.fn(PG_IS_IN_RECOVERY))).Scan(&inRecovery)
+	if err != nil {
+		log.Log.Error(err, "could not get postgres recovery state")
+		return false, err
+	}
+	return inRecovery, nil
+}
+
+// prepareStandby checks the server is a standby, and returns replay paused
+// state if replay is paused during quiesce
+func (pg *PG) prepareStandby() (map[string]string, error) {
+	db, err := sql.Open("postgres", pg.getConnectionString(pg.database))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	inRecovery, err := pg.isInRecovery(db)
+	if err != nil {
+		return nil, err
+	}
+	if !inRecovery {
+		return nil, fmt.Errorf("postgres %s is not a standby", pg.config.Host)
+	}
+	if !pg.isNonExclusive() {
+		return nil, fmt.Errorf("backup from standby requires non-exclusive backup, current version: %s, flavor: %s", pg.version, pg.flavor)
+	}
+
+	if !pg.replayPause {
+		return nil, nil
+	}
+
+	var paused bool
+	err = db.QueryRow(fmt.Sprintf("SELECT %s();", pg.getReplayFunc(PG_IS_WAL_REPLAY_PAUSED, PG_IS_XLOG_REPLAY_PAUSED))).Scan(&paused)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{WalReplayPaused: fmt.Sprintf("%t", paused)}, nil
+}
+
+// pauseReplay freezes the standby, pause is requested asynchronously since
+// v14 so it's waited until paused
+func (pg *PG) pauseReplay(s *session.Session) error {
+	err := s.Exec(fmt.Sprintf("SELECT %s();", pg.getReplayFunc(PG_WAL_REPLAY_PAUSE, PG_XLOG_REPLAY_PAUSE)))
+	if err != nil {
+		return err
+	}
+
+	if pg.versionAtLeast(ReplayPauseStateMinVersion) {
+		var state string
+		err = wait.PollImmediate(ReplayPausePollingInterval, DefaultReplayPauseTimeout, func() (bool, error) {
+			err := s.QueryRow(fmt.Sprintf("SELECT %s();", pg.fn(PG_GET_WAL_REPLAY_PAUSE_STATE)), &state)
+			if err != nil {
+				return false, err
+			}
+			return state == ReplayPausedState, nil
+		})
+		if err != nil {
+			return fmt.Errorf("postgres wal replay is not paused, state: %s, err: %v", state, err)
+		}
+	}
+
+	log.Log.Info("postgres wal replay paused", "instance", pg.config.Name)
+	return nil
+}
+
+// getReplayLSN records the last wal location replayed by the standby
+func (pg *PG) getReplayLSN(s *session.Session, result *v1alpha1.PgResult) error {
+	var lsn sql.NullString
+	err := s.QueryRow(fmt.Sprintf("SELECT %s();", pg.getReplayFunc(PG_LAST_WAL_REPLAY_LSN, PG_LAST_XLOG_REPLAY_LOCATION)), &lsn)
+	if err != nil {
+		return err
+	}
+	result.ReplayLSN = lsn.String
+	log.Log.Info("postgres standby replay lsn", "lsn", result.ReplayLSN, "instance", pg.config.Name)
+	return nil
+}
+
+// resumeReplay resumes wal replay if it was not paused before quiesce, it's
+// kept by server so a new connection is used
+func (pg *PG) resumeReplay(prev *v1alpha1.PreservedConfig) error {
+	if prev != nil && prev.Params[WalReplayPaused] == "true" {
+		log.Log.Info("postgres wal replay was paused before quiesce, skip resuming", "instance", pg.config.Name)
+		return nil
+	}
+
+	db, err := sql.Open("postgres", pg.getConnectionString(pg.database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(fmt.Sprintf("SELECT %s();", pg.getReplayFunc(PG_WAL_REPLAY_RESUME, PG_XLOG_REPLAY_RESUME)))
+	if err != nil {
+		return err
+	}
+
+	log.Log.Info("postgres wal replay resumed", "instance", pg.config.Name)
+	return nil
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/jibudata/amberapp/api/v1alpha1"
+	"github.com/jibudata/amberapp/pkg/session"
+)
+
+const (
+	// < v10.0
+	PG_XLOGFILE_NAME = "pg_xlogfile_name"
+	// >= v10.0
+	PG_WALFILE_NAME = "pg_walfile_name"
+
+	PG_CONTROL_SYSTEM     = "pg_control_system"
+	PG_CONTROL_CHECKPOINT = "pg_control_checkpoint"
+
+	// unit of wal_segment_size is 8kB before v11
+	WalSegmentSizeQuery = "SELECT setting::bigint * CASE unit WHEN '8kB' THEN 8192 ELSE 1 END FROM pg_settings WHERE name = 'wal_segment_size';"
+)
+
+func (pg *PG) getWalFileNameCmd(lsn string) string {
+	if pg.versionAtLeast(WalFunctionMinVersion) {
+		return fmt.Sprintf("SELECT %s(%s);", pg.fn(PG_WALFILE_NAME), pq.QuoteLiteral(lsn))
+	}
+	return fmt.Sprintf("SELECT %s(%s);", pg.fn(PG_XLOGFILE_NAME), pq.QuoteLiteral(lsn))
+}
+
+// getWalFileName returns name of the wal segment holding the lsn
+func (pg *PG) getWalFileName(s *session.Session, lsn string) (string, error) {
+	if pg.standby {
+		return pg.computeWalFileName(s, lsn)
+	}
+
+	var walFile string
+	err := s.QueryRow(pg.getWalFileNameCmd(lsn), &walFile)
+	if err != nil {
+		return "", err
+	}
+	return walFile, nil
+}
+
+// computeWalFileName builds the wal file name from timeline and lsn, wal file
+// name functions cannot be executed during recovery
+func (pg *PG) computeWalFileName(s *session.Session, lsn string) (string, error) {
+	var timeline, segmentSize uint64
+	err := s.QueryRow(fmt.Sprintf("SELECT timeline_id FROM %s();", pg.fn(PG_CONTROL_CHECKPOINT)), &timeline)
+	if err != nil {
+		return "", err
+	}
+	err = s.QueryRow(WalSegmentSizeQuery, &segmentSize)
+	if err != nil {
+		return "", err
+	}
+	return walFileName(timeline, segmentSize, lsn)
+}
+
+// walFileName returns name of the wal segment holding the lsn in the timeline
+func walFileName(timeline, segmentSize uint64, lsn string) (string, error) {
+	if segmentSize == 0 {
+		return "", fmt.Errorf("invalid wal segment size")
+	}
+
+	location, err := parseLSN(lsn)
+	if err != nil {
+		return "", err
+	}
+
+	segment := location / segmentSize
+	segmentsPerID := uint64(0x100000000) / segmentSize
+	return fmt.Sprintf("%08X%08X%08X", timeline, segment/segmentsPerID, segment%segmentsPerID), nil
+}
+
+// parseLSN converts lsn in X/X format to a number
+func parseLSN(lsn string) (uint64, error) {
+	parts := strings.Split(lsn, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid lsn %s", lsn)
+	}
+	high, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %s, err: %v", lsn, err)
+	}
+	low, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %s, err: %v", lsn, err)
+	}
+	return high<<32 | low, nil
+}
+
+// parseTimeline returns the timeline encoded in first 8 hex digits of wal file name
+func parseTimeline(walFile string) (int64, error) {
+	if len(walFile) < 8 {
+		return 0, fmt.Errorf("invalid wal file name %s", walFile)
+	}
+	return strconv.ParseInt(walFile[:8], 16, 64)
+}
+
+// getStartWal fills start wal segment, timeline and system identifier in result
+func (pg *PG) getStartWal(s *session.Session, result *v1alpha1.PgResult) error {
+	var err error
+	if pg.versionAtLeast(ControlFunctionMinVersion) {
+		err = s.QueryRow(fmt.Sprintf("SELECT system_identifier FROM %s();", pg.fn(PG_CONTROL_SYSTEM)), &result.SystemIdentifier)
+		if err != nil {
+			return err
+		}
+	}
+
+	result.StartWalFile, err = pg.getWalFileName(s, result.StartLSN)
+	if err != nil {
+		return err
+	}
+	result.Timeline, err = parseTimeline(result.StartWalFile)
+	return err
+}
+
+// getStopWal fills stop wal segment in result
+func (pg *PG) getStopWal(s *session.Session, result *v1alpha1.PgResult) error {
+	var err error
+	result.StopWalFile, err = pg.getWalFileName(s, result.StopLSN)
+	if err != nil {
+		return err
+	}
+	log.Log.Info("postgres backup wal range", "start", result.StartWalFile, "stop", result.StopWalFile, "instance", pg.config.Name)
+	return nil
+}
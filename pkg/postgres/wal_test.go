@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"testing"
+)
+
+func TestWalFileName(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeline    uint64
+		segmentSize uint64
+		lsn         string
+		want        string
+		wantErr     bool
+	}{
+		{name: "16MB", timeline: 1, segmentSize: 16 << 20, lsn: "0/3000028", want: "000000010000000000000003"},
+		{name: "16MB segment boundary", timeline: 1, segmentSize: 16 << 20, lsn: "0/4000000", want: "000000010000000000000004"},
+		{name: "16MB segment end", timeline: 1, segmentSize: 16 << 20, lsn: "0/3FFFFFF", want: "000000010000000000000003"},
+		{name: "16MB high log id", timeline: 3, segmentSize: 16 << 20, lsn: "1/A0000000", want: "0000000300000001000000A0"},
+		{name: "1GB", timeline: 2, segmentSize: 1 << 30, lsn: "2/C0000028", want: "000000020000000200000003"},
+		{name: "1GB segment boundary", timeline: 1, segmentSize: 1 << 30, lsn: "0/40000000", want: "000000010000000000000001"},
+		{name: "1GB segment end", timeline: 1, segmentSize: 1 << 30, lsn: "0/3FFFFFFF", want: "000000010000000000000000"},
+		{name: "zero segment size", timeline: 1, segmentSize: 0, lsn: "0/3000028", wantErr: true},
+		{name: "invalid lsn", timeline: 1, segmentSize: 16 << 20, lsn: "3000028", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walFileName(tt.timeline, tt.segmentSize, tt.lsn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("walFileName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("walFileName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLSN(t *testing.T) {
+	tests := []struct {
+		lsn     string
+		want    uint64
+		wantErr bool
+	}{
+		{lsn: "0/0", want: 0},
+		{lsn: "0/3000028", want: 0x3000028},
+		{lsn: "16/B374D848", want: 0x16B374D848},
+		{lsn: "FFFFFFFF/FFFFFFFF", want: 0xFFFFFFFFFFFFFFFF},
+		{lsn: "", wantErr: true},
+		{lsn: "1/2/3", wantErr: true},
+		{lsn: "0/XYZ", wantErr: true},
+		{lsn: "100000000/0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lsn, func(t *testing.T) {
+			got, err := parseLSN(tt.lsn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLSN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseLSN() = %X, want %X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeline(t *testing.T) {
+	tests := []struct {
+		walFile string
+		want    int64
+		wantErr bool
+	}{
+		{walFile: "000000010000000000000003", want: 1},
+		{walFile: "0000000A00000001000000A0", want: 10},
+		{walFile: "000000FF", want: 255},
+		{walFile: "0001", wantErr: true},
+		{walFile: "0000000G0000000000000003", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.walFile, func(t *testing.T) {
+			got, err := parseTimeline(tt.walFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimeline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTimeline() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
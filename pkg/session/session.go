@@ -0,0 +1,301 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// interval to ping the pinned session
+	KeepaliveInterval = 10 * time.Second
+	// timeout of a single query issued on the session
+	QueryTimeout = 30 * time.Second
+)
+
+// ErrLost is wrapped by errors reporting the session is lost
+var ErrLost = errors.New("session is lost")
+
+// ErrInProgress is wrapped by errors reporting the query started in background
+// is not done yet
+var ErrInProgress = errors.New("query is in progress")
+
+// Session pins one database connection for the whole quiesce window, locks
+// or backup state held by the connection are released by server once the
+// connection is gone
+type Session struct {
+	db      *sql.DB
+	conn    *sql.Conn
+	id      int64
+	idQuery string
+
+	mu   sync.Mutex
+	lost error
+	// query started in background holds the connection until it's done
+	busy bool
+
+	// result and cancel of the query started in background
+	pending chan queryResult
+	cancel  context.CancelFunc
+
+	stop chan struct{}
+}
+
+type queryResult struct {
+	rows []map[string]string
+	err  error
+}
+
+// New opens a dedicated connection, idQuery returns the server side id of the
+// connection which is used to confirm the session is not changed
+func New(driverName, dsn, idQuery string) (*Session, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// the pool only serves the pinned connection, never recycle it
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &Session{
+		db:      db,
+		conn:    conn,
+		idQuery: idQuery,
+		stop:    make(chan struct{}),
+	}
+
+	err = conn.QueryRowContext(ctx, idQuery).Scan(&s.id)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ID returns server side id of the session
+func (s *Session) ID() int64 {
+	return s.id
+}
+
+func (s *Session) Exec(query string, args ...interface{}) error {
+	return s.ExecWithTimeout(query, QueryTimeout, args...)
+}
+
+func (s *Session) ExecWithTimeout(query string, timeout time.Duration, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := s.conn.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (s *Session) QueryRow(query string, dest ...interface{}) error {
+	return s.QueryRowWithTimeout(query, QueryTimeout, dest...)
+}
+
+func (s *Session) QueryRowWithTimeout(query string, timeout time.Duration, dest ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.conn.QueryRowContext(ctx, query).Scan(dest...)
+}
+
+// QueryRows returns all rows of the query as column name to value maps
+func (s *Session) QueryRows(query string, args ...interface{}) ([]map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QueryTimeout)
+	defer cancel()
+
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return ScanRows(rows)
+}
+
+// Start runs the query in background so the caller is not blocked by a long
+// running query, the result is taken by Wait
+func (s *Session) Start(query string, timeout time.Duration) error {
+	if s.pending != nil {
+		return fmt.Errorf("%w, session id: %d", ErrInProgress, s.id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	done := make(chan queryResult, 1)
+	s.pending = done
+	s.cancel = cancel
+	s.setBusy(true)
+
+	go func() {
+		defer cancel()
+		defer s.setBusy(false)
+
+		rows, err := s.conn.QueryContext(ctx, query)
+		if err != nil {
+			done <- queryResult{err: err}
+			return
+		}
+		defer rows.Close()
+
+		result, err := ScanRows(rows)
+		done <- queryResult{rows: result, err: err}
+	}()
+	return nil
+}
+
+// Wait waits up to timeout for the query started by Start and returns its
+// first row, ErrInProgress is returned if the query is still running
+func (s *Session) Wait(timeout time.Duration) (map[string]string, error) {
+	if s.pending == nil {
+		return nil, fmt.Errorf("no query started on session %d", s.id)
+	}
+
+	select {
+	case result := <-s.pending:
+		s.pending = nil
+		s.cancel = nil
+		if result.err != nil {
+			return nil, result.err
+		}
+		if len(result.rows) == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return result.rows[0], nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%w, session id: %d", ErrInProgress, s.id)
+	}
+}
+
+func (s *Session) setBusy(busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.busy = busy
+}
+
+func (s *Session) isBusy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.busy
+}
+
+// Check confirms the session is still the same server connection
+func (s *Session) Check() error {
+	var id int64
+	err := s.QueryRow(s.idQuery, &id)
+	if err != nil {
+		return fmt.Errorf("%w, session id: %d, err: %v", ErrLost, s.id, err)
+	}
+	if id != s.id {
+		return fmt.Errorf("%w, session id: %d, current session id: %d", ErrLost, s.id, id)
+	}
+	return nil
+}
+
+// Keepalive checks the session periodically until it's closed, the session
+// is marked as lost once the check failed
+func (s *Session) Keepalive() {
+	go func() {
+		ticker := time.NewTicker(KeepaliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				// the connection is held by the query started in background
+				if s.isBusy() {
+					continue
+				}
+				err := s.Check()
+				if err != nil {
+					log.Log.Error(err, "session keepalive failed")
+					s.mu.Lock()
+					s.lost = err
+					s.mu.Unlock()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Err returns the reason if the session is lost
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lost
+}
+
+// Close stops keepalive and closes the pinned connection, the query started
+// in background is cancelled and the connection is closed once it's done
+func (s *Session) Close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+
+	if s.pending != nil {
+		s.cancel()
+		go func(done chan queryResult) {
+			<-done
+			s.conn.Close()
+			s.db.Close()
+		}(s.pending)
+		s.pending = nil
+		s.cancel = nil
+		return
+	}
+
+	s.conn.Close()
+	s.db.Close()
+}
+
+func ScanRows(rows *sql.Rows) ([]map[string]string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = values[i].String
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}